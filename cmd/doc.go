@@ -24,5 +24,220 @@ The flags are:
 		apply changes to the repository without asking for validation or displaying
 		new commit dates.
 
+	--range REV
+		only rewrite commits of the revision range REV, which must end at HEAD
+		(e.g: origin/master..HEAD). A single revision REV is understood as REV..HEAD.
+
+	--unpushed
+		only rewrite commits not pushed yet, i.e: commits after the merge-base
+		between the current branch and its upstream.
+
+	--allow-published
+		allow rewriting commits reachable from remote-tracking refs. Without this
+		flag histoctl refuses to rewrite commits which have already been pushed.
+
+	--no-verify
+		skip the verification of the rewritten history before overriding the
+		branch. By default histoctl walks the original and rewritten histories
+		in parallel and refuses to override the branch if their parent
+		structure, or their trees (unless files are rewritten, e.g: with
+		--remove-path), differ. When commits are dropped, squashed or moved,
+		each remaining commit is compared to the commit it is rewritten as:
+		parents must be the rewritten ones, unless the commit has been moved,
+		trees must match until changes of a dropped or moved commit are lost,
+		and every remaining commit must be reachable from the rewritten branch.
+
+	--autostash
+		stash uncommitted changes before the rewrite and reapply them once it
+		is over, like git rebase --autostash. Without this flag histoctl
+		refuses to run on a working directory with uncommitted changes to
+		tracked files. If changes do not apply cleanly they are kept in the
+		stash.
+
+	-S/--sign
+		sign rewritten commits the way git commit -S does: gpg.format selects
+		the kind of key (openpgp, default, or ssh), user.signingkey the key and
+		gpg.program or gpg.ssh.program the program to run. Signatures of
+		rewritten commits are invalidated, a warning is displayed when signed
+		commits are rewritten.
+
+	--continue
+		resume a rewrite interrupted by a signal or a crash, with the options
+		it was started with. Progress is journaled in .git/historiography/,
+		commits already replayed are not replayed again. Takes a single
+		repository.
+
+	--abort
+		roll back an interrupted rewrite: checkout the original branch, delete
+		the temporary branch and the journal. Changes stashed by --autostash
+		and not reapplied yet are reapplied.
+
+	--empty MODE
+		handling of empty commits, i.e: commits with the same tree as their
+		parent. "keep" (default) keeps them, "drop" drops commits emptied by the
+		rewrite (e.g: with --remove-path) but keeps intentional empty commits,
+		"drop-all" drops every empty commit. Merge and root commits are kept.
+
+	--squash MODE
+		squash commits into a single commit per group before publishing, "day"
+		squashes commits of each day, "session" squashes commits of each
+		session, i.e: commits authored less than --session-gap apart. Squashed
+		commits take the tree and the (rescheduled) dates of the last commit of
+		the group, their message lists subjects of the group. Dates are
+		rescheduled considering squashed commits only, groups whose changes
+		cancel out are handled according to --empty. Can not be combined with
+		--drop or --edit-plan.
+
+	--session-gap DURATION
+		maximal duration between two commits of a session (default 30m).
+
+	--drop EXPR
+		drop commits matching the expression EXPR, see --when for the syntax.
+		Changes of dropped commits are removed from following commits.
+
+	--edit-plan
+		open the plan of the rewrite in an editor, like git rebase -i does.
+		Commits can be dropped or reordered, and their parents changed with
+		"pick <commit> parents=<commit>,<commit>". Commits moved are merged on
+		top of the previous line, the rewrite stops if their changes conflict.
+
+	--update-refs MODE
+		once the branch is overridden, look for other references (branches,
+		refs/stash, remote-tracking refs...) whose history contains rewritten
+		commits. "report" lists them, "repoint" also moves references pointing
+		to a rewritten commit, "rebase" also replays commits of other
+		references on top of rewritten history. Tags and notes are handled by
+		--rewrite-tags and --notes-ref.
+
+	--rewrite-tags
+		once the branch is overridden, move tags pointing to rewritten commits.
+		Lightweight tags are moved, annotated tags are recreated with the same
+		tagger and message, signatures of signed tags are removed.
+
+	--process-tags
+		with --rewrite-tags, change taggers as committers are changed
+		(--mailmap, --author, --email, --committer-name, --committer-email)
+		and shift tagger dates by the date change of the tagged commits.
+
+	--notes-ref REF
+		once the branch is overridden, copy notes of REF (e.g: refs/notes/ci,
+		globs allowed) from original commits to rewritten ones. Can be
+		repeated, defaults to GIT_NOTES_REWRITE_REF or notes.rewriteRef like
+		git does. Notes of squashed commits are concatenated.
+
+	--process-notes
+		apply message changes (--replace-message, --message-prefix...) and
+		redaction (--redact...) on copied notes.
+
+	--submodules
+		rewrite initialized submodules (recursively) before the superproject,
+		with the same changes, then update submodule entries (gitlinks) of the
+		superproject trees to point to rewritten commits of submodules, so
+		checkouts of the rewritten history still work. Every commit of the
+		checked out branch (or detached HEAD) of submodules is rewritten:
+		--range, --commits and the selection (--rev, --since, --when...) only
+		apply to the superproject, --unpushed and --allow-published apply to
+		submodules too. The superproject is locked and checked before its
+		submodules are rewritten. Submodules and the superproject are verified
+		then overridden together, none of them is if one fails.
+		--continue and --abort also apply to submodules.
+
+	--rev REV
+		only alter commits of the revision range REV (A..B, ^X, Y). Can be
+		repeated, e.g: --rev ^X --rev Y. Other commits are replayed untouched,
+		content changes (--remove-path, --strip-blobs-bigger-than,
+		--replace-text) apply to every commit whatever the selection.
+
+	--since DATE, --until DATE
+		only alter commits authored between dates (2006-01-02 or RFC3339).
+
+	--match-author REGEXP
+		only alter commits whose author, formatted as "Name <email>", matches
+		REGEXP.
+
+	--path PATHSPEC
+		only alter commits whose changes against their first parent touch paths
+		matching PATHSPEC (e.g: docs/). Can be repeated.
+
+	--author NAME, --email EMAIL
+		replace name or email of both author and committer on all commits.
+
+	--author-name NAME, --author-email EMAIL
+		replace name or email of the author only, committer is kept.
+
+	--committer-name NAME, --committer-email EMAIL
+		replace name or email of the committer only, author is kept.
+
+	--mailmap FILE
+		rewrite author and committer identities listed in FILE, other identities
+		are kept. FILE is a git mailmap, or a CSV (old_name, old_email, new_name,
+		new_email) or YAML (list of {old: {name, email}, new: {name, email}})
+		file according to its extension.
+
+	--replace-message FILE
+		replace text in commit messages. Each line of FILE is a replacement:
+		"literal==>replacement", "regex:pattern==>replacement" or "literal"
+		alone, in which case it is replaced by ***REMOVED***.
+
+	--message-prefix TEMPLATE, --message-suffix TEMPLATE
+		prepend or append a text/template to commit messages. Templates can use
+		commit fields: {{.ID}}, {{.ShortID}}, {{.Author.Name}}, {{.Author.Email}},
+		{{.Committer.Name}}, {{.Summary}}, {{.Message}}...
+
+	--normalize-message
+		normalise line endings, invalid UTF-8 sequences, trailing whitespaces
+		and blank lines of commit messages.
+
+	--trailer-add "KEY: VALUE", --trailer-remove KEY, --trailer-rename OLD=NEW
+		add, remove or rename trailers of commit messages. Can be repeated, the
+		first --trailer-rename matching a key applies.
+		Identities found in trailers (e.g: Co-authored-by) follow identity
+		changes made by --mailmap, --author, --email...
+
+	--signoff
+		add a Signed-off-by trailer for the (rewritten) author of each commit.
+
+	--redact, --redact-pattern REGEXP, --redact-skip DETECTOR
+		replace sensitive content of commit messages by ***REDACTED*** and
+		print a report of redactions. --redact enables built-in detectors:
+		aws-access-key, github-token, slack-token, google-api-key, private-key,
+		jwt, secret-assignment, email and high-entropy, which can be disabled
+		one by one with --redact-skip. --redact-pattern adds custom patterns.
+		Trailers (e.g: Signed-off-by) are not redacted, identities they hold
+		are changed along with authors and committers.
+
+	--remove-path PATTERN
+		remove files matching PATTERN from all commits, e.g: "*.log" or
+		"vendor/big". Patterns without slash match file names, others match
+		paths from the repository root. Can be repeated.
+
+	--strip-blobs-bigger-than SIZE
+		remove files bigger than SIZE (e.g: 512K, 10M) from all commits.
+
+	--replace-text FILE
+		replace text in files of all commits, FILE has the same format as for
+		--replace-message. Binary files are left untouched.
+
+	--when EXPR
+		only replace identities and messages (--author, --mailmap,
+		--replace-message...) on commits satisfying the expression, e.g:
+		--when 'author.email =~ "@oldcorp.com$"'.
+		Comparisons over author.name, author.email, committer.name,
+		committer.email, message (=~, !~, ==, !=), date (<, <=, >, >=),
+		path (==, != against a pathspec, =~, !~ against a regexp) and
+		parents (==, !=, <, <=, >, >=) can be combined with &&, ||, ! and
+		parentheses. Values are double quoted, dates included, \" and \\ being
+		the only escape sequences, e.g:
+		--when 'date >= "2020-01-01" && path =~ "\.go$"'.
+
+Verify:
+
+  histoctl verify [--repo path] [--ignore-trees] <old> <new>
+
+walks the histories ending at revisions old and new in parallel and lists
+differences of parent structure and trees, e.g: to check a rewrite against the
+previous position of the branch (histoctl verify master@{1} master). Trees are
+not compared with --ignore-trees.
+
 */
 package main
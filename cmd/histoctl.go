@@ -2,20 +2,27 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/golang/glog"
+	histo "github.com/paul-bismuth/historiography"
 	"github.com/spf13/cobra"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
 	force     bool
 	debug     bool
 	verbosity int
-	commits   int
-	author    string
-	email     string
+	options   histo.Options
+	selected  selection
+	changed   changes
+	empty     string
+	sign      bool
+	resuming  bool
+	aborting  bool
 )
 
 var root = &cobra.Command{
@@ -27,7 +34,9 @@ var root = &cobra.Command{
 			verbosity = 5
 		}
 
-		// remove args from command line in order to avoid collision with glog
+		// remove args from command line in order to avoid collision with glog,
+		// they are kept in the journal of the rewrite
+		options.Args = os.Args[1:]
 		os.Args = os.Args[:1]
 
 		// re-set flags to pass down config to glog as flag.Parse() is not called
@@ -45,22 +54,141 @@ var root = &cobra.Command{
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cmd.SilenceUsage = true // do not show usage if an error is returned
-		return run(args, commits, author, email)
+		switch {
+		case aborting:
+			return abort(args)
+		case resuming:
+			return resume(cmd, args)
+		}
+		if err := parseModes(); err != nil {
+			return err
+		}
+		return run(args, &options, &selected, &changed)
 	},
 }
 
+// Parse flags whose values are modes.
+func parseModes() (err error) {
+	if options.Empty, err = emptyMode(empty); err != nil {
+		return
+	}
+	// squashed commits are not replayed according to a plan
+	if changed.squash != "" && changed.drop != "" {
+		return fmt.Errorf("--squash can not be combined with --drop")
+	}
+	if changed.squash != "" && changed.edit {
+		return fmt.Errorf("--squash can not be combined with --edit-plan")
+	}
+	if changed.refs != "" {
+		changed.refMode, err = refMode(changed.refs)
+	}
+	return
+}
+
 // Init the root command of histoctl with flags and bind them to viper or internal variables.
 func init() {
 	root.PersistentFlags().BoolVarP(&force, "force", "f", false,
 		"force change, no review of rescheduling")
 	root.PersistentFlags().BoolVar(&debug, "debug", false, "debug mode")
 	root.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "verbose")
-	root.PersistentFlags().IntVarP(&commits, "commits", "c", -1,
+	root.PersistentFlags().IntVarP(&options.Commits, "commits", "c", -1,
 		"number of commits to take into account when rescheduling\n (nth latest)")
-	root.PersistentFlags().StringVar(&author, "author", "",
+	root.PersistentFlags().StringVar(&options.Range, "range", "",
+		"revision range ending at HEAD to reschedule (e.g: origin/master..HEAD)")
+	root.PersistentFlags().BoolVar(&options.Unpushed, "unpushed", false,
+		"only reschedule commits not pushed to the upstream branch yet")
+	root.PersistentFlags().BoolVar(&options.AllowPublished, "allow-published", false,
+		"allow rewriting commits reachable from remote-tracking refs")
+	root.PersistentFlags().BoolVar(&options.NoVerify, "no-verify", false,
+		"do not check the rewritten history against the original one")
+	root.PersistentFlags().BoolVar(&options.AutoStash, "autostash", false,
+		"stash uncommitted changes before rewriting and reapply them afterwards")
+	root.PersistentFlags().BoolVarP(&sign, "sign", "S", false,
+		"sign rewritten commits with user.signingkey (gpg.format openpgp or ssh)")
+	root.PersistentFlags().BoolVar(&resuming, "continue", false,
+		"resume an interrupted rewrite with its options")
+	root.PersistentFlags().BoolVar(&aborting, "abort", false,
+		"roll back an interrupted rewrite")
+	root.PersistentFlags().StringVar(&empty, "empty", "keep",
+		"empty commits handling: keep, drop (commits emptied by the rewrite)\n"+
+			"or drop-all")
+	root.PersistentFlags().StringVar(&changed.squash, "squash", "",
+		"squash commits of each day (day) or of each session (session)")
+	root.PersistentFlags().DurationVar(&changed.gap, "session-gap", 30*time.Minute,
+		"maximal duration between two commits of a session")
+	root.PersistentFlags().StringVar(&changed.drop, "drop", "",
+		"drop commits matching the expression (see --when)")
+	root.PersistentFlags().BoolVar(&changed.edit, "edit-plan", false,
+		"edit the list of commits to replay (pick, drop, reorder) in an editor")
+	root.PersistentFlags().StringVar(&changed.refs, "update-refs", "",
+		"references pointing into rewritten history: report, repoint or rebase")
+	root.PersistentFlags().BoolVar(&changed.tags, "rewrite-tags", false,
+		"move tags pointing to rewritten commits")
+	root.PersistentFlags().BoolVar(&changed.processTags, "process-tags", false,
+		"apply identity and date changes on taggers of rewritten tags")
+	root.PersistentFlags().StringArrayVar(&changed.notes, "notes-ref", nil,
+		"copy notes of the ref (glob allowed) to rewritten commits, can be repeated\n"+
+			"(default notes.rewriteRef)")
+	root.PersistentFlags().BoolVar(&changed.processNotes, "process-notes", false,
+		"apply message changes and redaction on copied notes")
+	root.PersistentFlags().BoolVar(&changed.submodules, "submodules", false,
+		"rewrite submodules too and update gitlinks to their rewritten commits")
+	root.PersistentFlags().StringArrayVar(&selected.revisions, "rev", nil,
+		"only alter commits of revision ranges (A..B, ^X, Y), can be repeated")
+	root.PersistentFlags().StringVar(&selected.since, "since", "",
+		"only alter commits authored after date (2006-01-02 or RFC3339)")
+	root.PersistentFlags().StringVar(&selected.until, "until", "",
+		"only alter commits authored before date (2006-01-02 or RFC3339)")
+	root.PersistentFlags().StringVar(&selected.author, "match-author", "",
+		"only alter commits whose author (\"Name <email>\") matches regexp")
+	root.PersistentFlags().StringArrayVar(&selected.paths, "path", nil,
+		"only alter commits touching paths matching pathspec, can be repeated")
+	root.PersistentFlags().StringVar(&selected.when, "when", "",
+		"only replace identities and messages on commits satisfying expression,\n"+
+			"values and dates are double quoted (e.g: 'author.email =~ \"@oldcorp.com$\"'\n"+
+			"or 'date >= \"2020-01-01\"')")
+	root.PersistentFlags().StringVar(&changed.name, "author", "",
 		"replace author by new one on all commits.")
-	root.PersistentFlags().StringVar(&email, "email", "",
+	root.PersistentFlags().StringVar(&changed.email, "email", "",
 		"replace email by new one on all commits.")
+	root.PersistentFlags().StringVar(&changed.authorName, "author-name", "",
+		"replace author name only, committer is kept.")
+	root.PersistentFlags().StringVar(&changed.authorEmail, "author-email", "",
+		"replace author email only, committer is kept.")
+	root.PersistentFlags().StringVar(&changed.committerName, "committer-name", "",
+		"replace committer name only, author is kept.")
+	root.PersistentFlags().StringVar(&changed.committerEmail, "committer-email", "",
+		"replace committer email only, author is kept.")
+	root.PersistentFlags().StringVar(&changed.mailmap, "mailmap", "",
+		"rewrite identities found in a mailmap file (or .csv/.yaml mapping).")
+	root.PersistentFlags().StringVar(&changed.replacements, "replace-message", "",
+		"replace text in messages according to a replacements file.")
+	root.PersistentFlags().StringVar(&changed.prefix, "message-prefix", "",
+		"prepend template to messages (e.g: '[{{.ShortID}}] ').")
+	root.PersistentFlags().StringVar(&changed.suffix, "message-suffix", "",
+		"append template to messages.")
+	root.PersistentFlags().BoolVar(&changed.normalize, "normalize-message", false,
+		"normalise whitespaces and encoding of messages.")
+	root.PersistentFlags().StringArrayVar(&changed.trailers, "trailer-add", nil,
+		"add trailer (\"Key: value\") to messages, can be repeated.")
+	root.PersistentFlags().StringArrayVar(&changed.remove, "trailer-remove", nil,
+		"remove trailers with key from messages, can be repeated.")
+	root.PersistentFlags().StringArrayVar(&changed.rename, "trailer-rename", nil,
+		"rename trailers keys (Old=New), can be repeated.")
+	root.PersistentFlags().BoolVar(&changed.signoff, "signoff", false,
+		"add a Signed-off-by trailer for the author of each commit.")
+	root.PersistentFlags().BoolVar(&changed.redact, "redact", false,
+		"redact secrets, tokens and emails found in messages.")
+	root.PersistentFlags().StringArrayVar(&changed.patterns, "redact-pattern", nil,
+		"redact matches of regexp in messages, can be repeated.")
+	root.PersistentFlags().StringArrayVar(&changed.skip, "redact-skip", nil,
+		"disable a built-in redaction detector (e.g: email), can be repeated.")
+	root.PersistentFlags().StringArrayVar(&changed.paths, "remove-path", nil,
+		"remove files matching pattern from all commits, can be repeated.")
+	root.PersistentFlags().StringVar(&changed.blobLimit, "strip-blobs-bigger-than", "",
+		"remove files bigger than size (e.g: 10M) from all commits.")
+	root.PersistentFlags().StringVar(&changed.content, "replace-text", "",
+		"replace text in files according to a replacements file.")
 
 }
 
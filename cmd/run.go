@@ -1,9 +1,20 @@
 package main
 
 import (
+	"fmt"
 	"github.com/golang/glog"
 	histo "github.com/paul-bismuth/historiography"
+	"github.com/spf13/cobra"
 	git "gopkg.in/libgit2/git2go.v26"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
 )
 
@@ -13,20 +24,341 @@ const endHour = 18
 
 var closedDays = []time.Weekday{time.Saturday, time.Sunday}
 
-func newComposerProcessor(name, email string) *histo.ComposerProcessor {
+// Changes to apply on commits, as given on command line.
+type changes struct {
+	name, email                   string
+	authorName, authorEmail       string
+	committerName, committerEmail string
+	mailmap                       string
+	replacements                  string
+	prefix, suffix                string
+	normalize                     bool
+	trailers, remove, rename      []string
+	signoff                       bool
+	redact                        bool
+	patterns, skip                []string
+	paths                         []string
+	blobLimit                     string
+	content                       string
+	squash                        string
+	gap                           time.Duration
+	drop                          string
+	edit                          bool
+	tags, processTags             bool
+	notes                         []string
+	processNotes                  bool
+	refs                          string
+	refMode                       histo.RefMode
+	submodules                    bool
+}
+
+// Parse a size given on command line, e.g: 512, 100K, 10M or 1G.
+func parseSize(value string) (int64, error) {
+	units := map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+	value = strings.TrimSuffix(strings.ToUpper(value), "B")
+	multiplier := int64(1)
+	if value == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	if unit, ok := units[value[len(value)-1:]]; ok {
+		value, multiplier = value[:len(value)-1], unit
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	return size * multiplier, err
+}
+
+// Build processors rewriting trees from command line.
+func (chg *changes) treeProcessors(repo *git.Repository) (processors []histo.Processer, err error) {
+	if len(chg.paths) != 0 {
+		processors = append(processors, &histo.RemovePathsProcessor{Repo: repo, Patterns: chg.paths})
+	}
+	if chg.blobLimit != "" {
+		var limit int64
+		if limit, err = parseSize(chg.blobLimit); err != nil {
+			return nil, fmt.Errorf("invalid --strip-blobs-bigger-than size: %s", err)
+		}
+		processors = append(processors, &histo.BlobSizeProcessor{Repo: repo, Limit: limit})
+	}
+	if chg.content != "" {
+		var replacements []histo.Replacement
+		if replacements, err = histo.LoadReplacements(chg.content); err != nil {
+			return
+		}
+		processors = append(processors,
+			&histo.ReplaceContentProcessor{Repo: repo, Replacements: replacements})
+	}
+	return
+}
+
+// Build the redaction processor from command line, nil if nothing to redact.
+func (chg *changes) redactProcessor() (*histo.RedactProcessor, error) {
+	rp := &histo.RedactProcessor{}
+	if chg.redact {
+		for _, detector := range histo.Detectors {
+			skip := false
+			for _, name := range chg.skip {
+				skip = skip || name == detector.Name
+			}
+			if !skip {
+				rp.Detectors = append(rp.Detectors, detector)
+			}
+		}
+	}
+	for i, p := range chg.patterns {
+		pattern, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --redact-pattern: %s", err)
+		}
+		rp.Detectors = append(rp.Detectors, histo.Detector{
+			Name: fmt.Sprintf("pattern-%d", i+1), Pattern: pattern,
+		})
+	}
+	if len(rp.Detectors) == 0 {
+		return nil, nil
+	}
+	return rp, nil
+}
+
+// Build the trailer processor from command line, nil if trailers are kept.
+func (chg *changes) trailerProcessor() (tp *histo.TrailerProcessor, err error) {
+	if len(chg.trailers) == 0 && len(chg.remove) == 0 && len(chg.rename) == 0 && !chg.signoff {
+		return
+	}
+	tp = &histo.TrailerProcessor{Remove: chg.remove, SignOff: chg.signoff}
+	for _, t := range chg.trailers {
+		trailer, err := histo.ParseTrailer(t)
+		if err != nil {
+			return nil, err
+		}
+		tp.Add = append(tp.Add, trailer)
+	}
+	for _, r := range chg.rename {
+		keys := strings.SplitN(r, "=", 2)
+		if len(keys) != 2 || keys[0] == "" || keys[1] == "" {
+			return nil, fmt.Errorf("invalid trailer renaming %q, expected Old=New", r)
+		}
+		tp.Rename = append(tp.Rename, histo.TrailerRename{Old: keys[0], New: keys[1]})
+	}
+	return
+}
+
+// Build the message processor from command line, nil if messages are kept.
+func (chg *changes) messageProcessor() (mp *histo.MessageProcessor, err error) {
+	if chg.replacements == "" && chg.prefix == "" && chg.suffix == "" && !chg.normalize {
+		return
+	}
+	mp = &histo.MessageProcessor{Normalize: chg.normalize}
+	if chg.replacements != "" {
+		if mp.Replacements, err = histo.LoadReplacements(chg.replacements); err != nil {
+			return
+		}
+	}
+	if chg.prefix != "" {
+		if mp.Prefix, err = template.New("prefix").Parse(chg.prefix); err != nil {
+			return
+		}
+	}
+	if chg.suffix != "" {
+		if mp.Suffix, err = template.New("suffix").Parse(chg.suffix); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Build processors changing identities from command line, and the mailmap
+// alone, nil if there is none.
+func (chg *changes) identityProcessors() (identities []histo.Processer, mapper histo.IdentityMapper, err error) {
+	if chg.mailmap != "" {
+		var mappings []histo.IdentityMapping
+		if mappings, err = histo.LoadIdentities(chg.mailmap); err != nil {
+			return
+		}
+		ip := &histo.IdentityProcessor{mappings, histo.BothRoles}
+		identities, mapper = append(identities, ip), ip
+	}
+
+	// generic replacements first so specific ones override them
+	names := []struct {
+		name, email string
+		role        histo.Role
+	}{
+		{chg.name, chg.email, histo.BothRoles},
+		{chg.authorName, chg.authorEmail, histo.AuthorRole},
+		{chg.committerName, chg.committerEmail, histo.CommitterRole},
+	}
+	for _, n := range names {
+		if n.name != "" {
+			identities = append(identities, &histo.NameProcessor{n.name, n.role})
+		}
+		if n.email != "" {
+			identities = append(identities, &histo.EmailProcessor{n.email, n.role})
+		}
+	}
+	return
+}
+
+func newComposerProcessor(repo *git.Repository, chg *changes, when histo.Predicate) (*histo.ComposerProcessor, error) {
 	// init processors
 	processors := []histo.Processer{
 		&histo.DateProcessor{closedDays, startHour, endHour, make(map[git.Oid]time.Time)},
 	}
 
-	// add more processors if needed
-	if name != "" {
-		processors = append(processors, &histo.NameProcessor{name})
+	// add more processors if needed, applied only on commits satisfying the
+	// condition if there is one
+	add := func(p histo.Processer) {
+		if when != nil {
+			p = &histo.ConditionalProcessor{when, p}
+		}
+		processors = append(processors, p)
+	}
+	// identity processors are kept apart, trailers have to follow their changes
+	identities, mapper, err := chg.identityProcessors()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range identities {
+		add(p)
+	}
+
+	mp, err := chg.messageProcessor()
+	if err != nil {
+		return nil, err
+	}
+	if mp != nil {
+		add(mp)
+	}
+
+	// redaction covers text added by message changes, but not trailers (e.g:
+	// Signed-off-by) whose identities are mapped afterwards
+	rp, err := chg.redactProcessor()
+	if err != nil {
+		return nil, err
+	}
+	if rp != nil {
+		add(rp)
+	}
+
+	tp, err := chg.trailerProcessor()
+	if err != nil {
+		return nil, err
+	}
+	if tp != nil {
+		tp.Signatures, tp.Identities = &histo.ComposerProcessor{identities}, mapper
+		if when != nil { // identities only changed on commits satisfying condition
+			tp.Signatures = &histo.ConditionalProcessor{when, tp.Signatures}
+		}
+		add(tp)
+	}
+
+	// content changes apply on every commit, a condition would resurrect files
+	tree, err := chg.treeProcessors(repo)
+	if err != nil {
+		return nil, err
+	}
+	processors = append(processors, tree...)
+
+	// dropped commits are given as an expression, no need for a condition
+	if chg.drop != "" {
+		condition, err := histo.ParseExpression(repo, chg.drop)
+		if err != nil {
+			return nil, err
+		}
+		processors = append(processors, &histo.DropProcessor{condition})
+	}
+	return &histo.ComposerProcessor{processors}, nil
+}
+
+// Parse the handling of empty commits given on command line.
+func emptyMode(value string) (histo.EmptyMode, error) {
+	modes := map[string]histo.EmptyMode{
+		"keep": histo.KeepEmpty, "drop": histo.DropEmpty, "drop-all": histo.DropAllEmpty,
 	}
-	if email != "" {
-		processors = append(processors, &histo.EmailProcessor{email})
+	if mode, ok := modes[value]; ok {
+		return mode, nil
+	}
+	return histo.KeepEmpty, fmt.Errorf("invalid --empty value %q, expected keep, drop or drop-all", value)
+}
+
+// Group commits to squash according to the squash mode given on command line,
+// nil means commits are not squashed.
+func (chg *changes) groups(commits []histo.Commits) ([]histo.Commits, error) {
+	switch chg.squash {
+	case "":
+		return nil, nil
+	case "day":
+		return commits, nil
+	case "session":
+		if chg.gap <= 0 {
+			return nil, fmt.Errorf("invalid --session-gap %s, expected a positive duration", chg.gap)
+		}
+		return histo.Sessions(histo.Flatten(commits), chg.gap), nil
 	}
-	return &histo.ComposerProcessor{processors}
+	return nil, fmt.Errorf("invalid --squash value %q, expected day or session", chg.squash)
+}
+
+// Selection of commits processors are applied to, as given on command line.
+type selection struct {
+	revisions    []string
+	since, until string
+	author       string
+	paths        []string
+	when         string
+}
+
+// Parse a date given on command line, either a day or a full RFC3339 date.
+func parseDate(value string) (time.Time, error) {
+	if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Build the predicate corresponding to the selection for a repository, nil is
+// returned if there is no selection at all.
+func (s *selection) predicate(repo *git.Repository) (histo.Predicate, error) {
+	predicates := histo.AllPredicate{}
+
+	if len(s.revisions) != 0 {
+		revisions, err := histo.NewRevisionPredicate(repo, s.revisions...)
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, revisions)
+	}
+
+	if s.since != "" || s.until != "" {
+		var err error
+		dates := &histo.DatePredicate{}
+		if s.since != "" {
+			if dates.Since, err = parseDate(s.since); err != nil {
+				return nil, fmt.Errorf("invalid --since date: %s", err)
+			}
+		}
+		if s.until != "" {
+			if dates.Until, err = parseDate(s.until); err != nil {
+				return nil, fmt.Errorf("invalid --until date: %s", err)
+			}
+		}
+		predicates = append(predicates, dates)
+	}
+
+	if s.author != "" {
+		pattern, err := regexp.Compile(s.author)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --match-author pattern: %s", err)
+		}
+		predicates = append(predicates, &histo.AuthorPredicate{pattern})
+	}
+
+	if len(s.paths) != 0 {
+		predicates = append(predicates, &histo.PathPredicate{repo, s.paths, nil})
+	}
+
+	if len(predicates) == 0 {
+		return nil, nil
+	}
+	return predicates, nil
 }
 
 func filter(commits histo.Commits, start int) []histo.Commits {
@@ -37,12 +369,23 @@ func filter(commits histo.Commits, start int) []histo.Commits {
 	return iterator.Commits
 }
 
-func run(args []string, nb int, name, email string) (err error) {
+func run(args []string, opts *histo.Options, sel *selection, chg *changes) (err error) {
 	var repo *git.Repository
 	var commits []histo.Commits
 	var historiography *histo.Historiography
+	var processor *histo.ComposerProcessor
+	var when histo.Predicate
+	var subs []*submodule
+
+	// signals only interrupt rewrites, see interruption
+	interrupted := newInterruption()
+	defer interrupted.stop()
+	defer func() {
+		if err == histo.ErrInterrupted {
+			err = fmt.Errorf("%s, resume it with --continue or roll it back with --abort", err)
+		}
+	}()
 
-	processor := newComposerProcessor(name, email)
 	for _, arg := range args {
 		if repo, err = git.OpenRepository(arg); err != nil {
 			return
@@ -53,55 +396,615 @@ func run(args []string, nb int, name, email string) (err error) {
 		}
 
 		defer repo.Free()
+
+		// selection may depend on the repository (revisions)
+		if opts.Filter, err = sel.predicate(repo); err != nil {
+			return
+		}
+		opts.Sign = nil
+		if sign {
+			if opts.Sign, err = histo.NewSigner(repo); err != nil {
+				return
+			}
+		}
+		if sel.when != "" {
+			if when, err = histo.ParseExpression(repo, sel.when); err != nil {
+				return
+			}
+		}
+		if processor, err = newComposerProcessor(repo, chg, when); err != nil {
+			return
+		}
+
+		// gitlinks follow commits of submodules, whatever the selection
+		var links *histo.GitlinkProcessor
+		if chg.submodules {
+			links = &histo.GitlinkProcessor{Repo: repo, Rewritten: map[git.Oid]git.Oid{}}
+			processor.Processors = append(processor.Processors, links)
+		}
+
 		if glog.V(5) {
 			glog.Infof("%q", commits) // display all commits retrieved in debug mode
 		}
 
-		// init historiography struct
-		if historiography, err = histo.NewHistoriography(repo, processor, nb); err != nil {
+		// init historiography struct, the superproject is locked and checked
+		// before any submodule is rewritten
+		if historiography, err = histo.NewHistoriography(repo, processor, opts); err != nil {
 			return
 		}
 		// be sure to free resources when ending
 		defer historiography.Free()
+		interrupted.add(historiography)
+
+		// submodules are rewritten first, gitlinks follow their commits
+		subs = nil
+		if chg.submodules {
+			if subs, err = prepareSubmodules(repo, opts, chg, interrupted); err != nil {
+				return
+			}
+			defer freeSubmodules(subs)
+			for old, new := range mappings(subs) {
+				links.Rewritten[old] = new
+			}
+		}
 
 		commits = historiography.Commits
 
-		// infers changes needed to be in sync with the distribution strategy
-		if err = historiography.Preprocess(commits); err != nil {
+		// apply changes on the temporary branch
+		if err = rewrite(historiography, repo, processor, chg); err != nil {
 			return
 		}
 
-		// logs changes in a convenient if verbosity is high enough
-		if glog.V(2) {
-			if dp, ok := processor.Processors[0].(*histo.DateProcessor); ok {
-				logs(commits, dp)
-			}
+		// ask for confirmation if needed and override branches
+		if err = confirm(historiography, repo, chg, interrupted, subs); err != nil {
+			return
 		}
+	}
+	return
+}
 
-		// apply changes on the temporary branch
-		if err = historiography.Process(histo.Flatten(commits)); err != nil {
-			return
+// Rewrite commits on the temporary branch of a rewrite, squashing them if
+// asked, and report redactions. Branches are not overridden, see confirm.
+func rewrite(h *histo.Historiography, repo *git.Repository, processor *histo.ComposerProcessor,
+	chg *changes) (err error) {
+	commits := h.Commits
+
+	// rewritten commits can not keep their signatures, tell it loudly
+	if err = warnSigned(repo, histo.Flatten(commits), sign); err != nil {
+		return
+	}
+
+	// infers changes needed to be in sync with the distribution strategy, on
+	// the commits published once squashed if asked
+	var groups []histo.Commits
+	if groups, err = chg.groups(commits); err != nil {
+		return
+	}
+	if groups != nil {
+		err = h.PreprocessGroups(groups)
+	} else {
+		err = h.Preprocess(commits)
+	}
+	if err != nil {
+		return
+	}
+	if h.Interrupted() {
+		return histo.ErrInterrupted
+	}
+
+	// logs changes in a convenient if verbosity is high enough
+	if glog.V(2) {
+		if dp, ok := processor.Processors[0].(*histo.DateProcessor); ok {
+			logs(commits, dp)
 		}
+	}
 
-		// ask for confirmation if needed and override branch
-		if err = confirm(historiography, repo); err != nil {
-			return
+	// squash commits if asked, replay them according to their plan otherwise
+	if groups != nil {
+		err = h.Squash(groups)
+	} else {
+		err = replay(h, repo, chg.edit)
+	}
+	if err != nil {
+		return
+	}
+
+	// display what has been redacted from messages before validation
+	return report(processor)
+}
+
+// A submodule rewritten along with its superproject.
+type submodule struct {
+	path string
+	repo *git.Repository
+	h    *histo.Historiography
+}
+
+// Rewrite initialized submodules of a repository on their temporary branches,
+// nested submodules first, see rewrite. They are overridden along with the
+// superproject, see confirm. Every commit of their checked out branch is
+// rewritten: the range and the selection of the superproject do not apply,
+// Unpushed and AllowPublished do. Submodules are freed on error.
+func prepareSubmodules(repo *git.Repository, opts *histo.Options, chg *changes,
+	interrupted *interruption) (subs []*submodule, err error) {
+	defer func() {
+		if err != nil {
+			freeSubmodules(subs)
+			subs = nil
+		}
+	}()
+
+	paths := []string{}
+	err = repo.Submodules.Foreach(func(sub *git.Submodule, name string) int {
+		paths = append(paths, sub.Path())
+		return 0
+	})
+	if err != nil {
+		return
+	}
+
+	for _, path := range paths {
+		workdir := filepath.Join(repo.Workdir(), path)
+		if _, e := os.Stat(filepath.Join(workdir, ".git")); e != nil {
+			glog.Warningf("submodule %s is not initialized, skipped", path)
+			continue
+		}
+		sub := &submodule{path: path}
+		if sub.repo, err = git.OpenRepository(workdir); err != nil {
+			return subs, fmt.Errorf("submodule %s: %s", path, err)
+		}
+		if err = prepareSubmodule(sub, opts, chg, interrupted, &subs); err != nil {
+			return subs, fmt.Errorf("submodule %s: %s", path, err)
 		}
 	}
 	return
 }
 
+// Rewrite a submodule and its own submodules, see prepareSubmodules. They are
+// appended to subs, the submodule last, so that they are freed on error.
+func prepareSubmodule(sub *submodule, opts *histo.Options, chg *changes,
+	interrupted *interruption, subs *[]*submodule) (err error) {
+	nested, err := prepareSubmodules(sub.repo, opts, chg, interrupted)
+	*subs = append(append(*subs, nested...), sub)
+	if err != nil {
+		return
+	}
+
+	subOpts := histo.Options{Commits: -1, Unpushed: opts.Unpushed, AllowPublished: opts.AllowPublished,
+		Empty: opts.Empty, NoVerify: opts.NoVerify, Args: opts.Args, AutoStash: opts.AutoStash}
+	if sign {
+		if subOpts.Sign, err = histo.NewSigner(sub.repo); err != nil {
+			return
+		}
+	}
+	// submodules rewritten before an interruption are resumed, others are
+	// rewritten from scratch
+	if opts.Resume {
+		var journal *histo.Journal
+		if journal, err = histo.ReadJournal(sub.repo); err != nil {
+			return
+		}
+		if journal != nil {
+			if err = journal.Restore(sub.repo); err != nil {
+				return
+			}
+			subOpts.Resume = true
+		}
+	}
+
+	processor, err := newComposerProcessor(sub.repo, chg, nil)
+	if err != nil {
+		return
+	}
+	if links := mappings(nested); len(links) > 0 {
+		processor.Processors = append(processor.Processors,
+			&histo.GitlinkProcessor{Repo: sub.repo, Rewritten: links})
+	}
+
+	if sub.h, err = histo.NewHistoriography(sub.repo, processor, &subOpts); err != nil {
+		return
+	}
+	interrupted.add(sub.h)
+	return rewrite(sub.h, sub.repo, processor, chg)
+}
+
+// Free rewrites of submodules and their repositories, in reverse order.
+func freeSubmodules(subs []*submodule) {
+	for i := len(subs) - 1; i >= 0; i-- {
+		if subs[i].h != nil {
+			subs[i].h.Free()
+		}
+		subs[i].repo.Free()
+	}
+}
+
+// Merge mappings of rewritten commits of submodules.
+func mappings(subs []*submodule) map[git.Oid]git.Oid {
+	links := map[git.Oid]git.Oid{}
+	for _, sub := range subs {
+		for old, new := range sub.h.Mapping() {
+			links[old] = new
+		}
+	}
+	return links
+}
+
+// Interruption of rewrites on SIGINT or SIGTERM. The handler only flags
+// registered rewrites, see Historiography.Interrupt, the main goroutine stops
+// at the next commit or step and frees them, keeping their journals.
+type interruption struct {
+	mutex    sync.Mutex
+	rewrites []*histo.Historiography
+	done     chan struct{} // closed on interruption, for prompts
+	signals  chan os.Signal
+}
+
+// Start listening to signals, until stop is called.
+func newInterruption() *interruption {
+	in := &interruption{done: make(chan struct{}), signals: make(chan os.Signal, 1)}
+	signal.Notify(in.signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-in.signals; ok {
+			fmt.Fprintln(os.Stderr, "interrupting, the rewrite stops after the current commit")
+			in.mutex.Lock()
+			for _, h := range in.rewrites {
+				h.Interrupt()
+			}
+			close(in.done)
+			in.mutex.Unlock()
+		}
+		for range in.signals { // further signals are ignored until stop
+		}
+	}()
+	return in
+}
+
+// Register a rewrite, interrupted right away if a signal has been received.
+func (in *interruption) add(h *histo.Historiography) {
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+	in.rewrites = append(in.rewrites, h)
+	select {
+	case <-in.done:
+		h.Interrupt()
+	default:
+	}
+}
+
+// Stop listening to signals.
+func (in *interruption) stop() {
+	signal.Stop(in.signals)
+	close(in.signals)
+}
+
+// Resume the interrupted rewrite of a repository with the options it was
+// started with.
+func resume(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("--continue takes a single repository")
+	}
+	repo, err := git.OpenRepository(args[0])
+	if err != nil {
+		return err
+	}
+	journal, err := histo.ReadJournal(repo)
+	if err == nil && journal == nil {
+		err = fmt.Errorf("no rewrite to continue in %s", args[0])
+	}
+	if err == nil {
+		err = journal.Restore(repo)
+	}
+	repo.Free()
+	if err != nil {
+		return err
+	}
+
+	if err = cmd.ParseFlags(journal.Args); err != nil {
+		return err
+	}
+	if err = parseModes(); err != nil {
+		return err
+	}
+	options.Args, options.Resume = journal.Args, true
+	return run(args, &options, &selected, &changed)
+}
+
+// Roll back interrupted rewrites of repositories.
+func abort(args []string) error {
+	for _, arg := range args {
+		repo, err := git.OpenRepository(arg)
+		if err != nil {
+			return err
+		}
+		journal, err := histo.ReadJournal(repo)
+		if err == nil && journal == nil {
+			err = fmt.Errorf("no rewrite to abort in %s", arg)
+		}
+		if err == nil {
+			err = journal.Abort(repo)
+		}
+		if err == nil {
+			err = abortSubmodules(repo, journal.Args)
+		}
+		repo.Free()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Roll back interrupted rewrites of submodules started by the same command
+// line as their superproject, see --submodules.
+func abortSubmodules(repo *git.Repository, args []string) error {
+	paths := []string{}
+	err := repo.Submodules.Foreach(func(sub *git.Submodule, name string) int {
+		paths = append(paths, sub.Path())
+		return 0
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		workdir := filepath.Join(repo.Workdir(), path)
+		if _, err = os.Stat(filepath.Join(workdir, ".git")); err != nil {
+			continue // not initialized, not rewritten
+		}
+		sub, err := git.OpenRepository(workdir)
+		if err != nil {
+			return err
+		}
+		journal, err := histo.ReadJournal(sub)
+		if err == nil && journal != nil &&
+			strings.Join(journal.Args, "\x00") == strings.Join(args, "\x00") {
+			err = journal.Abort(sub)
+		}
+		if err == nil {
+			err = abortSubmodules(sub, args)
+		}
+		sub.Free()
+		if err != nil {
+			return fmt.Errorf("submodule %s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// Replay commits according to their plan, edited by the user if asked.
+func replay(h *histo.Historiography, repo *git.Repository, edit bool) error {
+	commits := histo.Flatten(h.Commits)
+	plan, err := h.Plan(commits)
+	if err != nil {
+		return err
+	}
+	if edit {
+		if plan, err = histo.EditPlan(repo, plan, commits); err != nil {
+			return err
+		}
+	}
+	return h.Replay(plan)
+}
+
+// Warn on stderr when signed commits are about to be rewritten, their
+// signatures are dropped, or replaced by ours if signing.
+func warnSigned(repo *git.Repository, commits histo.Commits, signing bool) error {
+	signed, err := histo.Signed(repo, commits)
+	if err != nil || len(signed) == 0 {
+		return err
+	}
+	action := "dropped, use --sign to re-sign them"
+	if signing {
+		action = "replaced by signatures of user.signingkey"
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: %d signed commits will be rewritten, their signatures will be %s\n",
+		len(signed), action)
+	return nil
+}
+
+// Print redactions performed by redaction processors on stdout.
+func report(processor *histo.ComposerProcessor) error {
+	for _, p := range processor.Processors {
+		if cp, ok := p.(*histo.ConditionalProcessor); ok {
+			p = cp.Processor
+		}
+		if rp, ok := p.(*histo.RedactProcessor); ok {
+			if err := rp.Report(os.Stdout); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Wrapper for the confirmation, call override from historiography object if
 // user validate changes, or directly override if force flag has been passed.
-func confirm(h *histo.Historiography, repo *git.Repository) (err error) {
+// Rewrites of submodules are checked along with the superproject and
+// overridden before it, all of them or none. An interruption cancels the
+// prompt.
+func confirm(h *histo.Historiography, repo *git.Repository, chg *changes,
+	interrupted *interruption, subs []*submodule) (err error) {
 	ok := force // not a good design has to be improved
 	if !ok {
-		ok, err = histo.Confirm(repo)
+		for _, sub := range subs {
+			fmt.Printf("submodule %s is overridden along with %s\n", sub.path, repo.Workdir())
+		}
+		if ok, err = prompt(repo, interrupted.done); err != nil || !ok {
+			return
+		}
 	}
-	if ok {
-		err = h.Override()
+
+	// nothing is overridden unless every rewrite is valid
+	for _, sub := range subs {
+		if err = sub.h.Check(); err != nil {
+			return fmt.Errorf("submodule %s: %s", sub.path, err)
+		}
 	}
-	return
+	if err = h.Check(); err != nil {
+		return
+	}
+	if err = override(h, subs); err != nil {
+		return
+	}
+
+	for _, sub := range subs {
+		if err = update(sub.h, sub.repo, chg); err != nil {
+			return fmt.Errorf("submodule %s: %s", sub.path, err)
+		}
+	}
+	return update(h, repo, chg)
+}
+
+// Override rewrites of submodules then the superproject, overridden rewrites
+// are reverted if one of them fails.
+func override(h *histo.Historiography, subs []*submodule) (err error) {
+	done := []*histo.Historiography{}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(done) - 1; i >= 0; i-- {
+			if e := done[i].Revert(); e != nil {
+				glog.Errorf("reverting rewrite failed: %s", e)
+			}
+		}
+	}()
+	for _, sub := range subs {
+		if err = sub.h.Override(); err != nil {
+			return fmt.Errorf("submodule %s: %s", sub.path, err)
+		}
+		done = append(done, sub.h)
+	}
+	return h.Override()
+}
+
+// Update references, tags and notes following an overridden rewrite, as
+// asked. An interruption only skips remaining updates.
+func update(h *histo.Historiography, repo *git.Repository, chg *changes) (err error) {
+	skipped := func() bool {
+		if h.Interrupted() {
+			err = fmt.Errorf("interrupted, references, tags and notes may not be updated")
+		}
+		return err != nil
+	}
+	// refs first, tags and notes follow their commits
+	if chg.refs != "" && !skipped() {
+		if err = updateRefs(h, chg.refMode); err != nil {
+			return
+		}
+	}
+	if chg.tags && !skipped() {
+		if err = rewriteTags(h, chg); err != nil {
+			return
+		}
+	}
+	if skipped() {
+		return
+	}
+	return copyNotes(h, repo, chg)
+}
+
+// Ask for confirmation, see histo.Confirm. The prompt is left behind on
+// interruption, it does not use the repository once the log is displayed.
+func prompt(repo *git.Repository, interrupted <-chan struct{}) (bool, error) {
+	type answer struct {
+		ok  bool
+		err error
+	}
+	answers := make(chan answer, 1)
+	go func() {
+		ok, err := histo.Confirm(repo)
+		answers <- answer{ok, err}
+	}()
+	select {
+	case a := <-answers:
+		return a.ok, a.err
+	case <-interrupted:
+		return false, histo.ErrInterrupted
+	}
+}
+
+// Parse the handling of references given on command line.
+func refMode(value string) (histo.RefMode, error) {
+	modes := map[string]histo.RefMode{
+		"report": histo.ReportRefs, "repoint": histo.RepointRefs, "rebase": histo.RebaseRefs,
+	}
+	if mode, ok := modes[value]; ok {
+		return mode, nil
+	}
+	return histo.ReportRefs, fmt.Errorf("invalid --update-refs value %q, expected report, repoint or rebase", value)
+}
+
+// Report or update references whose history contains rewritten commits.
+func updateRefs(h *histo.Historiography, mode histo.RefMode) error {
+	stale, err := h.UpdateRefs(mode)
+	for _, ref := range stale {
+		fmt.Println(ref)
+	}
+	return err
+}
+
+// Copy notes of rewritten commits, notes references default to the ones git
+// rewrites (notes.rewriteRef). Note texts go through message and redaction
+// changes if asked.
+func copyNotes(h *histo.Historiography, repo *git.Repository, chg *changes) (err error) {
+	refs := chg.notes
+	if len(refs) == 0 {
+		if refs, err = histo.NotesRewriteRefs(repo); err != nil || len(refs) == 0 {
+			return
+		}
+	}
+
+	var filter histo.MessageFilter
+	if chg.processNotes {
+		processors := []histo.Processer{}
+		mp, err := chg.messageProcessor()
+		if err != nil {
+			return err
+		}
+		if mp != nil {
+			processors = append(processors, mp)
+		}
+		rp, err := chg.redactProcessor()
+		if err != nil {
+			return err
+		}
+		if rp != nil {
+			processors = append(processors, rp)
+		}
+		filter = &histo.ComposerProcessor{processors}
+	}
+
+	count, err := h.CopyNotes(refs, filter)
+	if count > 0 {
+		glog.Infof("%d notes copied (%s)", count, strings.Join(refs, ", "))
+	}
+	return err
+}
+
+// Move tags pointing to rewritten commits, taggers are changed as committers
+// are (--mailmap, --author, --committer-email...) and according to the date
+// rules if asked.
+func rewriteTags(h *histo.Historiography, chg *changes) error {
+	opts := histo.TagOptions{Dates: chg.processTags}
+	if chg.processTags {
+		identities, _, err := chg.identityProcessors()
+		if err != nil {
+			return err
+		}
+		mappers := histo.IdentityMappers{}
+		for _, p := range identities {
+			mappers = append(mappers, p.(histo.IdentityMapper))
+		}
+		if len(mappers) > 0 {
+			opts.Identities = mappers
+		}
+	}
+	names, err := h.RewriteTags(opts)
+	for _, name := range names {
+		glog.Infof("tag %s rewritten", name)
+	}
+	return err
 }
 
 // Logs commits and changes through glog in a readable way.
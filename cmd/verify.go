@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	histo "github.com/paul-bismuth/historiography"
+	"github.com/spf13/cobra"
+	git "gopkg.in/libgit2/git2go.v26"
+)
+
+var (
+	repository  string
+	ignoreTrees bool
+)
+
+var verify = &cobra.Command{
+	Use:   "verify <old> <new>",
+	Short: "Check that a rewritten history matches the original one",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true // do not show usage if an error is returned
+		repo, err := git.OpenRepository(repository)
+		if err != nil {
+			return err
+		}
+		defer repo.Free()
+
+		diffs, err := histo.VerifyRevisions(repo, args[0], args[1], !ignoreTrees)
+		if err != nil {
+			return err
+		}
+		for _, diff := range diffs {
+			fmt.Println(diff)
+		}
+		if len(diffs) > 0 {
+			return fmt.Errorf("%d differences between %s and %s", len(diffs), args[0], args[1])
+		}
+		return nil
+	},
+}
+
+func init() {
+	verify.Flags().StringVar(&repository, "repo", ".", "repository to verify")
+	verify.Flags().BoolVar(&ignoreTrees, "ignore-trees", false,
+		"only compare parent structure, e.g: after --remove-path")
+	root.AddCommand(verify)
+}
@@ -2,6 +2,7 @@ package historiography
 
 import (
 	"fmt"
+	"golang.org/x/text/encoding"
 	git "gopkg.in/libgit2/git2go.v26"
 	"strings"
 	"time"
@@ -39,6 +40,25 @@ func Flatten(commits []Commits) (flat Commits) {
 	return
 }
 
+// Group commits in sessions: consecutive commits authored less than gap apart
+// belong to the same session. Commits are expected parents first.
+func Sessions(commits Commits, gap time.Duration) (sessions []Commits) {
+	var last time.Time
+	for i, commit := range commits {
+		date := commit.Author().When
+		diff := date.Sub(last)
+		if diff < 0 {
+			diff = -diff
+		}
+		if i == 0 || diff >= gap {
+			sessions = append(sessions, Commits{})
+		}
+		sessions[len(sessions)-1] = append(sessions[len(sessions)-1], commit)
+		last = date
+	}
+	return
+}
+
 // Define a strategy to alter commits.
 type Processer interface {
 	// First phase to run, allow user to have an overview of all commits of a
@@ -159,44 +179,124 @@ func (dp *DateProcessor) Process(commit *git.Commit) (a, c *git.Signature, m str
 	return
 }
 
-// This processor replace name for both author and committer in all commits
+// Processers deciding dates of commits in Preprocess, e.g: DateProcessor. The
+// schedule is kept in the journal, so a resumed rewrite gives commits the dates
+// decided by the interrupted one instead of new random ones.
+type Scheduler interface {
+	// Returns new dates per commit, the map is restored in place on resume.
+	Schedule() map[git.Oid]time.Time
+}
+
+// Returns new dates of commits decided by Preprocess.
+func (dp *DateProcessor) Schedule() map[git.Oid]time.Time { return dp.Changes }
+
+// Schedulers of a processer, composed ones included.
+func schedulers(p Processer) (res []Scheduler) {
+	switch p := p.(type) {
+	case *ComposerProcessor:
+		for _, processor := range p.Processors {
+			res = append(res, schedulers(processor)...)
+		}
+		return
+	case *ConditionalProcessor:
+		return schedulers(p.Processor)
+	}
+	if s, ok := p.(Scheduler); ok {
+		res = append(res, s)
+	}
+	return
+}
+
+// Indicates which signatures of a commit a processor operates on.
+type Role int
+
+const (
+	// Both author and committer, default value.
+	BothRoles Role = iota
+	AuthorRole
+	CommitterRole
+)
+
+// Indicates if the role covers the author signature.
+func (r Role) Author() bool { return r != CommitterRole }
+
+// Indicates if the role covers the committer signature.
+func (r Role) Committer() bool { return r != AuthorRole }
+
+// This processor replace name for author and/or committer in all commits
 // processed.
 type NameProcessor struct {
 	// New name to apply on commits.
 	Name string
+	// Signatures to change, both author and committer by default.
+	Target Role
 }
 
 // Preprocess is no-op for NameProcessor
 func (np *NameProcessor) Preprocess(_ Commits) error { return nil }
 
-// Change author and committer name to the new one defined by the structure.
+// Change author and/or committer name to the new one defined by the structure.
 func (np *NameProcessor) Process(commit *git.Commit) (a, c *git.Signature, m string, e error) {
 	m = commit.RawMessage()
 	a, c = commit.Author(), commit.Committer()
-	a.Name, c.Name = np.Name, np.Name
+	if np.Target.Author() {
+		a.Name = np.Name
+	}
+	if np.Target.Committer() {
+		c.Name = np.Name
+	}
 
 	return
 }
 
-// This processor replace email for both author and committer in all commits
+// Replace the name of an identity acting as a committer, e.g: a tagger, if
+// the committer is changed.
+func (np *NameProcessor) Map(sig *git.Signature) *git.Signature {
+	if !np.Target.Committer() {
+		return sig
+	}
+	res := *sig
+	res.Name = np.Name
+	return &res
+}
+
+// This processor replace email for author and/or committer in all commits
 // processed.
 type EmailProcessor struct {
 	// New email to apply on commits.
 	Email string
+	// Signatures to change, both author and committer by default.
+	Target Role
 }
 
 // Preprocess is no-op for EmailProcessor
 func (ep *EmailProcessor) Preprocess(_ Commits) error { return nil }
 
-// Change author and committer email to the new one defined by the structure.
+// Change author and/or committer email to the new one defined by the structure.
 func (ep *EmailProcessor) Process(commit *git.Commit) (a, c *git.Signature, m string, e error) {
 	m = commit.RawMessage()
 	a, c = commit.Author(), commit.Committer()
-	a.Email, c.Email = ep.Email, ep.Email
+	if ep.Target.Author() {
+		a.Email = ep.Email
+	}
+	if ep.Target.Committer() {
+		c.Email = ep.Email
+	}
 
 	return
 }
 
+// Replace the email of an identity acting as a committer, e.g: a tagger, if
+// the committer is changed.
+func (ep *EmailProcessor) Map(sig *git.Signature) *git.Signature {
+	if !ep.Target.Committer() {
+		return sig
+	}
+	res := *sig
+	res.Email = ep.Email
+	return &res
+}
+
 // Processor for composing multiple processors, order matter especially if
 // there is possibilities for override. ComposerProcessor is also a Processer
 // and run Preprocess/Process of embedded Processer in order of appearance.
@@ -205,6 +305,40 @@ type ComposerProcessor struct {
 	Processors []Processer
 }
 
+// Name of a processer as displayed in logs, e.g: "DateProcessor". Names of
+// composed processers are listed.
+func ProcesserName(p Processer) string {
+	switch p := p.(type) {
+	case *ComposerProcessor:
+		names := []string{}
+		for _, processor := range p.Processors {
+			names = append(names, ProcesserName(processor))
+		}
+		return strings.Join(names, ", ")
+	case *ConditionalProcessor:
+		return ProcesserName(p.Processor) + " (conditional)"
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", p), "*historiography.")
+}
+
+// Indicates if a processer changes content of commits, i.e: is or composes a
+// TreeProcesser.
+func ChangesTrees(p Processer) bool {
+	switch p := p.(type) {
+	case *ComposerProcessor:
+		for _, processor := range p.Processors {
+			if ChangesTrees(processor) {
+				return true
+			}
+		}
+		return false
+	case *ConditionalProcessor:
+		return ChangesTrees(p.Processor)
+	}
+	_, ok := p.(TreeProcesser)
+	return ok
+}
+
 // Run Preprocess of embedded Processer in order of appearance.
 func (cp *ComposerProcessor) Preprocess(commits Commits) error {
 	for _, processor := range cp.Processors {
@@ -235,10 +369,24 @@ func mergeSignature(res, new, old *git.Signature) {
 //
 //	Process -> processer_1.Process -> processer_2.Process
 //
+// Processers only changing messages, see filtersOnly, are chained afterwards
+// instead: each FilterMessage receives the message produced by previous ones.
 func (pc *ComposerProcessor) Process(commit *git.Commit) (a, c *git.Signature, m string, e error) {
+	return pc.process(commit, nil)
+}
+
+// Run Process, see Process, on a commit whose message is in encoding enc, nil
+// meaning UTF-8. Messages are filtered as UTF-8 then encoded back.
+func (pc *ComposerProcessor) process(commit *git.Commit, enc encoding.Encoding) (
+	a, c *git.Signature, m string, e error) {
 	m = commit.RawMessage()
 	a, c = commit.Author(), commit.Committer()
+	filters := &ComposerProcessor{}
 	for _, processor := range pc.Processors {
+		if filtersOnly(processor) {
+			filters.Processors = append(filters.Processors, processor)
+			continue
+		}
 		_a, _c, _m, _e := processor.Process(commit)
 		if e = _e; e != nil {
 			return
@@ -249,5 +397,145 @@ func (pc *ComposerProcessor) Process(commit *git.Commit) (a, c *git.Signature, m
 		mergeSignature(a, _a, commit.Author())
 		mergeSignature(c, _c, commit.Committer())
 	}
+	m, e = filterEncoded(filters, commit, m, enc)
 	return
 }
+
+// Indicates if a processer only changes messages, i.e: is a MessageFilter
+// leaving signatures untouched, conditionally or not.
+func filtersOnly(p Processer) bool {
+	switch p := p.(type) {
+	case *ConditionalProcessor:
+		return filtersOnly(p.Processor)
+	case *ComposerProcessor:
+		for _, processor := range p.Processors {
+			if !filtersOnly(processor) {
+				return false
+			}
+		}
+		return true
+	}
+	_, ok := p.(MessageFilter)
+	return ok
+}
+
+// Run ProcessTree of embedded processers implementing TreeProcesser in order of
+// appearance, each one receiving the tree produced by the previous one.
+func (pc *ComposerProcessor) ProcessTree(commit *git.Commit, tree *git.Tree) (*git.Tree, error) {
+	for _, processor := range pc.Processors {
+		if tp, ok := processor.(TreeProcesser); ok {
+			var err error
+			if tree, err = tp.ProcessTree(commit, tree); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tree, nil
+}
+
+// Run Plan of embedded processers implementing Planner in order of appearance,
+// each one receiving the plan produced by the previous one.
+func (pc *ComposerProcessor) Plan(plan Plan) (Plan, error) {
+	for _, processor := range pc.Processors {
+		if planner, ok := processor.(Planner); ok {
+			var err error
+			if plan, err = planner.Plan(plan); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return plan, nil
+}
+
+// Run FilterMessage of embedded processers in order of appearance, processers
+// which are not MessageFilter override the message if they change it.
+func (pc *ComposerProcessor) FilterMessage(commit *git.Commit, m string) (string, error) {
+	for _, processor := range pc.Processors {
+		if filter, ok := processor.(MessageFilter); ok {
+			var err error
+			if m, err = filter.FilterMessage(commit, m); err != nil {
+				return "", err
+			}
+			continue
+		}
+		_, _, _m, err := processor.Process(commit)
+		if err != nil {
+			return "", err
+		}
+		if commit.RawMessage() != _m {
+			m = _m
+		}
+	}
+	return m, nil
+}
+
+// Processor applying an embedded processer only on commits satisfying a
+// condition, other commits are left untouched.
+type ConditionalProcessor struct {
+	// Condition commits have to satisfy to be processed.
+	Condition Predicate
+	// Processer applied on commits satisfying the condition.
+	Processor Processer
+}
+
+// Run Preprocess of the embedded processer with commits satisfying the
+// condition only.
+func (cp *ConditionalProcessor) Preprocess(commits Commits) error {
+	selected := Commits{}
+	for _, commit := range commits {
+		ok, err := cp.Condition.Match(commit)
+		if err != nil {
+			return err
+		}
+		if ok {
+			selected = append(selected, commit)
+		}
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+	return cp.Processor.Preprocess(selected)
+}
+
+// Run Process of the embedded processer if the commit satisfies the condition,
+// otherwise commit informations are returned unchanged.
+func (cp *ConditionalProcessor) Process(commit *git.Commit) (a, c *git.Signature, m string, e error) {
+	ok, e := cp.Condition.Match(commit)
+	if e != nil {
+		return
+	}
+	if ok {
+		return cp.Processor.Process(commit)
+	}
+	return commit.Author(), commit.Committer(), commit.RawMessage(), nil
+}
+
+// Filter the message if the commit satisfies the condition, message is
+// returned unchanged otherwise.
+func (cp *ConditionalProcessor) FilterMessage(commit *git.Commit, m string) (string, error) {
+	ok, err := cp.Condition.Match(commit)
+	if err != nil || !ok {
+		return m, err
+	}
+	if filter, ok := cp.Processor.(MessageFilter); ok {
+		return filter.FilterMessage(commit, m)
+	}
+	_, _, _m, err := cp.Processor.Process(commit)
+	if err == nil && commit.RawMessage() != _m {
+		m = _m
+	}
+	return m, err
+}
+
+// Process the tree if the commit satisfies the condition and the embedded
+// processer is a TreeProcesser, tree is returned unchanged otherwise.
+func (cp *ConditionalProcessor) ProcessTree(commit *git.Commit, tree *git.Tree) (*git.Tree, error) {
+	tp, ok := cp.Processor.(TreeProcesser)
+	if !ok {
+		return tree, nil
+	}
+	if ok, err := cp.Condition.Match(commit); err != nil || !ok {
+		return tree, err
+	}
+	return tp.ProcessTree(commit, tree)
+}
@@ -0,0 +1,314 @@
+package historiography
+
+import (
+	"fmt"
+	git "gopkg.in/libgit2/git2go.v26"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Parse a predicate expression as written on the command line, e.g:
+//
+//	author.email =~ "@oldcorp.com$" && !(message =~ "^Merge")
+//
+// An expression is made of comparisons combined with &&, || and !, parentheses
+// can be used to group them. A comparison is a field, an operator and a value:
+//
+//	author.name, author.email, committer.name, committer.email, message:
+//		=~, !~ (regexp), ==, != (exact value)
+//	date (author date, 2006-01-02 or RFC3339):
+//		<, <=, >, >=
+//	path (touched paths against first parent):
+//		==, != (pathspec), =~, !~ (regexp)
+//	parents (number of parents):
+//		==, !=, <, <=, >, >=
+//
+// Values are double quoted strings, or numbers for parents. Dates have to be
+// quoted too, e.g: date >= "2006-01-02", dashes are not allowed out of strings.
+// Only \" and \\ are escape sequences, other backslashes are kept, e.g:
+// path =~ "\.go$".
+func ParseExpression(repo *git.Repository, expr string) (Predicate, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{repo: repo, tokens: tokens}
+	predicate, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in expression", p.tokens[p.pos])
+	}
+	return predicate, nil
+}
+
+// Split an expression in tokens, quoted strings are kept quoted.
+func tokenize(expr string) (tokens []string, err error) {
+	operators := []string{"&&", "||", "=~", "!~", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"}
+
+	for i := 0; i < len(expr); {
+		r := rune(expr[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '"': // find closing quote, skipping escaped ones
+			j := i + 1
+			for ; j < len(expr) && expr[j] != '"'; j++ {
+				if expr[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf("unterminated string in expression")
+			}
+			tokens, i = append(tokens, expr[i:j+1]), j+1
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i
+			for ; j < len(expr) && (unicode.IsLetter(rune(expr[j])) ||
+				unicode.IsDigit(rune(expr[j])) || expr[j] == '.' || expr[j] == '_'); j++ {
+			}
+			tokens, i = append(tokens, expr[i:j]), j
+			continue
+		}
+		found := false
+		for _, op := range operators {
+			if strings.HasPrefix(expr[i:], op) {
+				tokens, i, found = append(tokens, op), i+len(op), true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unexpected character %q in expression", expr[i])
+		}
+	}
+	return
+}
+
+// Recursive descent parser over tokens of an expression.
+type parser struct {
+	repo   *git.Repository
+	tokens []string
+	pos    int
+}
+
+// Return the current token without consuming it, empty at end of input.
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// Consume and return the current token.
+func (p *parser) next() (token string, err error) {
+	if token = p.peek(); token == "" {
+		err = fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+	return
+}
+
+// or := and ('||' and)*
+func (p *parser) or() (Predicate, error) {
+	left, err := p.and()
+	if err != nil || p.peek() != "||" {
+		return left, err
+	}
+	predicates := AnyPredicate{left}
+	for p.peek() == "||" {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, right)
+	}
+	return predicates, nil
+}
+
+// and := unary ('&&' unary)*
+func (p *parser) and() (Predicate, error) {
+	left, err := p.unary()
+	if err != nil || p.peek() != "&&" {
+		return left, err
+	}
+	predicates := AllPredicate{left}
+	for p.peek() == "&&" {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, right)
+	}
+	return predicates, nil
+}
+
+// unary := '!' unary | '(' or ')' | comparison
+func (p *parser) unary() (Predicate, error) {
+	switch p.peek() {
+	case "!":
+		p.pos++
+		predicate, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &NotPredicate{predicate}, nil
+	case "(":
+		p.pos++
+		predicate, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if token, err := p.next(); err != nil || token != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis in expression")
+		}
+		return predicate, nil
+	}
+	return p.comparison()
+}
+
+var fields = map[string]Field{
+	"author.name":     AuthorName,
+	"author.email":    AuthorEmail,
+	"committer.name":  CommitterName,
+	"committer.email": CommitterEmail,
+	"message":         Message,
+}
+
+// comparison := field operator value
+func (p *parser) comparison() (predicate Predicate, err error) {
+	var field, op, value string
+
+	for _, token := range []*string{&field, &op, &value} {
+		if *token, err = p.next(); err != nil {
+			return
+		}
+	}
+	if strings.HasPrefix(value, "\"") {
+		value = unquote(value)
+	}
+
+	switch {
+	case field == "date":
+		predicate, err = dateComparison(op, value)
+	case field == "path":
+		predicate, err = p.pathComparison(op, value)
+	case field == "parents":
+		predicate, err = parentsComparison(op, value)
+	default:
+		f, ok := fields[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q in expression", field)
+		}
+		pattern := value
+		if op == "==" || op == "!=" {
+			pattern = "^" + regexp.QuoteMeta(value) + "$"
+		}
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(pattern); err != nil {
+			return
+		}
+		predicate, err = negate(&RegexpPredicate{f, re}, op, "==", "=~")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s %s %q: %s", field, op, value, err)
+	}
+	return
+}
+
+// Remove quotes of a string token, only \" and \\ are unescaped so that
+// regexps can be written as is, e.g: "\.go$".
+func unquote(value string) string {
+	res := make([]byte, 0, len(value))
+	for i := 1; i < len(value)-1; i++ {
+		if value[i] == '\\' && (value[i+1] == '"' || value[i+1] == '\\') {
+			i++
+		}
+		res = append(res, value[i])
+	}
+	return string(res)
+}
+
+// Return the predicate for positive operators and its negation for !=, !~.
+func negate(predicate Predicate, op string, positive ...string) (Predicate, error) {
+	for _, o := range positive {
+		if o == op {
+			return predicate, nil
+		}
+	}
+	if op == "!=" || op == "!~" {
+		return &NotPredicate{predicate}, nil
+	}
+	return nil, fmt.Errorf("unsupported operator")
+}
+
+// Build a PathPredicate from a comparison over touched paths, value is a
+// pathspec for ==, != and a regexp for =~, !~.
+func (p *parser) pathComparison(op, value string) (Predicate, error) {
+	if op == "==" || op == "!=" {
+		return negate(&PathPredicate{p.repo, []string{value}, nil}, op, "==")
+	}
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return nil, err
+	}
+	return negate(&PathPredicate{p.repo, nil, re}, op, "=~")
+}
+
+// Build a DatePredicate from a comparison over author date, bounds of a
+// DatePredicate are inclusive. A day covers the whole day, e.g: date <= DAY
+// includes commits authored on DAY.
+func dateComparison(op, value string) (Predicate, error) {
+	date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	end := date.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	if err != nil {
+		if date, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("invalid date")
+		}
+		end = date
+	}
+	switch op {
+	case ">":
+		return &DatePredicate{Since: end.Add(time.Nanosecond)}, nil
+	case ">=":
+		return &DatePredicate{Since: date}, nil
+	case "<":
+		return &DatePredicate{Until: date.Add(-time.Nanosecond)}, nil
+	case "<=":
+		return &DatePredicate{Until: end}, nil
+	}
+	return nil, fmt.Errorf("unsupported operator")
+}
+
+// Build a ParentsPredicate from a comparison over the number of parents.
+func parentsComparison(op, value string) (Predicate, error) {
+	nb, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number")
+	}
+	switch op {
+	case "==":
+		return &ParentsPredicate{nb, nb}, nil
+	case "!=":
+		return &NotPredicate{&ParentsPredicate{nb, nb}}, nil
+	case "<":
+		if nb <= 0 {
+			return AnyPredicate{}, nil // never satisfied
+		}
+		return &ParentsPredicate{0, nb - 1}, nil
+	case "<=":
+		return &ParentsPredicate{0, nb}, nil
+	case ">":
+		return &ParentsPredicate{nb + 1, -1}, nil
+	case ">=":
+		return &ParentsPredicate{nb, -1}, nil
+	}
+	return nil, fmt.Errorf("unsupported operator")
+}
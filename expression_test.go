@@ -0,0 +1,112 @@
+package historiography
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Describe a predicate tree in a compact form, e.g: "all(re(1, @x), not(parents(2, -1)))".
+func describe(p Predicate) string {
+	list := func(name string, predicates []Predicate) string {
+		parts := make([]string, len(predicates))
+		for i, predicate := range predicates {
+			parts[i] = describe(predicate)
+		}
+		return fmt.Sprintf("%s(%s)", name, strings.Join(parts, ", "))
+	}
+	switch p := p.(type) {
+	case AllPredicate:
+		return list("all", p)
+	case AnyPredicate:
+		return list("any", p)
+	case *NotPredicate:
+		return fmt.Sprintf("not(%s)", describe(p.Predicate))
+	case *RegexpPredicate:
+		return fmt.Sprintf("re(%d, %s)", p.Field, p.Pattern)
+	case *PathPredicate:
+		return fmt.Sprintf("path(%s, %v)", strings.Join(p.Paths, ","), p.Pattern)
+	case *ParentsPredicate:
+		return fmt.Sprintf("parents(%d, %d)", p.Min, p.Max)
+	case *DatePredicate:
+		format := func(t time.Time) string {
+			if t.IsZero() {
+				return "-"
+			}
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+		return fmt.Sprintf("date(%s, %s)", format(p.Since), format(p.Until))
+	}
+	return fmt.Sprintf("%T", p)
+}
+
+func TestParseExpression(t *testing.T) {
+	// days are parsed in the local timezone, described in UTC
+	day := func(value string, offset time.Duration) string {
+		date, _ := time.ParseInLocation("2006-01-02", value, time.Local)
+		return date.Add(offset).UTC().Format(time.RFC3339Nano)
+	}
+	tests := []struct {
+		expr, expected, err string
+	}{
+		{`author.email =~ "@oldcorp.com$"`, `re(1, @oldcorp.com$)`, ""},
+		{`author.name == "A. Smith"`, `re(0, ^A\. Smith$)`, ""},
+		{`committer.email != "x@y.z"`, `not(re(3, ^x@y\.z$))`, ""},
+		{`message !~ "^Merge"`, `not(re(4, ^Merge))`, ""},
+		{`path == "docs/"`, `path(docs/, <nil>)`, ""},
+		{`path != "vendor/"`, `not(path(vendor/, <nil>))`, ""},
+		{`path =~ "\\.go$"`, `path(, \.go$)`, ""},
+		{`path !~ "_test\\.go$"`, `not(path(, _test\.go$))`, ""},
+		// as documented, backslashes other than \" and \\ are kept
+		{`path =~ "\.go$"`, `path(, \.go$)`, ""},
+		{`message =~ "say \"hi\"\s"`, `re(4, say "hi"\s)`, ""},
+		{`date >= "2020-01-01" && path =~ "\.go$"`, `all(date(` + day("2020-01-01", 0) + `, -), path(, \.go$))`, ""},
+		{`parents > 1`, `parents(2, -1)`, ""},
+		{`parents <= 1`, `parents(0, 1)`, ""},
+		{`parents < 0`, `any()`, ""},
+		{`parents != 1`, `not(parents(1, 1))`, ""},
+		{`date >= "2006-01-02T15:04:05Z"`, `date(2006-01-02T15:04:05Z, -)`, ""},
+		{`date < "2006-01-02T15:04:05Z"`, `date(-, 2006-01-02T15:04:04.999999999Z)`, ""},
+		{`date <= "2006-01-02T15:04:05Z"`, `date(-, 2006-01-02T15:04:05Z)`, ""},
+		// days are whole, from their first to their last nanosecond
+		{`date <= "2020-01-01"`, `date(-, ` + day("2020-01-02", -time.Nanosecond) + `)`, ""},
+		{`date > "2020-01-01"`, `date(` + day("2020-01-02", 0) + `, -)`, ""},
+		{`date < "2020-01-01"`, `date(-, ` + day("2020-01-01", -time.Nanosecond) + `)`, ""},
+		{
+			`author.name == "a" && committer.name == "b" || !(parents > 1)`,
+			`any(all(re(0, ^a$), re(2, ^b$)), not(parents(2, -1)))`, "",
+		},
+		{`!!(message =~ "x")`, `not(not(re(4, x)))`, ""},
+
+		{`date >= 2006-01-02`, "", `unexpected character '-'`},
+		{`author.name == "a`, "", "unterminated string"},
+		{`author.name`, "", "unexpected end of expression"},
+		{`author.age == "1"`, "", `unknown field "author.age"`},
+		{`author.name < "a"`, "", "unsupported operator"},
+		{`date == "2006-01-02"`, "", "unsupported operator"},
+		{`date > "yesterday"`, "", "invalid date"},
+		{`parents == "a"`, "", "invalid number"},
+		{`message =~ "("`, "", "missing closing )"},
+		{`path =~ "["`, "", "missing closing ]"},
+		{`(parents > 1`, "", "missing closing parenthesis"},
+		{`parents > 1 parents`, "", `unexpected "parents"`},
+	}
+
+	for _, test := range tests {
+		predicate, err := ParseExpression(nil, test.expr)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error %q, got %v", test.expr, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.expr, err)
+			continue
+		}
+		if res := describe(predicate); res != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.expr, test.expected, res)
+		}
+	}
+}
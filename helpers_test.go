@@ -0,0 +1,144 @@
+package historiography
+
+import (
+	"fmt"
+	git "gopkg.in/libgit2/git2go.v26"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Repository created in a temporary directory, driven through the git command
+// line to build histories and through git2go by the code under test.
+type testRepo struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+}
+
+// Base date of test commits, see testRepo.commit.
+var testDate = time.Date(2018, time.March, 5, 10, 0, 0, 0, time.UTC)
+
+// Create an empty repository, remove it with Free.
+func newTestRepo(t *testing.T) *testRepo {
+	dir, err := ioutil.TempDir("", "historiography")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &testRepo{t: t, dir: dir}
+	tr.git("init", "-q")
+	tr.git("symbolic-ref", "HEAD", "refs/heads/master")
+	if tr.repo, err = git.OpenRepository(dir); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return tr
+}
+
+// Free the repository and remove its directory.
+func (tr *testRepo) Free() {
+	tr.repo.Free()
+	os.RemoveAll(tr.dir)
+}
+
+// Environment of git commands, isolated from the configuration of the user.
+func (tr *testRepo) env(extra ...string) []string {
+	env := []string{}
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, "GIT_") && !strings.HasPrefix(v, "HOME=") {
+			env = append(env, v)
+		}
+	}
+	return append(append(env,
+		"HOME="+tr.dir, "GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=Author", "GIT_AUTHOR_EMAIL=author@example.com",
+		"GIT_COMMITTER_NAME=Committer", "GIT_COMMITTER_EMAIL=committer@example.com",
+	), extra...)
+}
+
+// Run a git command in the repository and return its trimmed output, the test
+// fails if the command does.
+func (tr *testRepo) git(args ...string) string {
+	return tr.gitEnv(nil, args...)
+}
+
+// Run a git command with additional environment variables, see git.
+func (tr *testRepo) gitEnv(env []string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir, cmd.Env = tr.dir, tr.env(env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		tr.t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Write files, given as path and content pairs, an empty content removing the
+// file, then commit them authored and committed at date. Returns the commit id.
+func (tr *testRepo) commit(date time.Time, message string, files ...string) string {
+	for i := 0; i+1 < len(files); i += 2 {
+		path := filepath.Join(tr.dir, files[i])
+		if files[i+1] == "" {
+			tr.git("rm", "-q", files[i])
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			tr.t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(files[i+1]), 0644); err != nil {
+			tr.t.Fatal(err)
+		}
+		tr.git("add", files[i])
+	}
+	stamp := fmt.Sprintf("%d +0000", date.Unix())
+	tr.gitEnv([]string{"GIT_AUTHOR_DATE=" + stamp, "GIT_COMMITTER_DATE=" + stamp},
+		"commit", "-q", "--allow-empty", "-m", message)
+	return tr.git("rev-parse", "HEAD")
+}
+
+// Look up a commit by id, the test fails if it does not exist.
+func (tr *testRepo) lookup(id string) *git.Commit {
+	oid, err := git.NewOid(id)
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	commit, err := tr.repo.LookupCommit(oid)
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	return commit
+}
+
+// Subjects of the commits of a revision, parents first.
+func (tr *testRepo) subjects(rev string) []string {
+	return strings.Split(tr.git("log", "--reverse", "--format=%s", rev), "\n")
+}
+
+// Rewrite the history with p and options opts, rewrite being called between the
+// creation and the override of the temporary branch. The test fails if any
+// step does.
+func (tr *testRepo) rewrite(p Processer, opts *Options, rewrite func(*Historiography) error) {
+	h, err := NewHistoriography(tr.repo, p, opts)
+	if err != nil {
+		tr.t.Fatalf("creating rewrite: %s", err)
+	}
+	defer h.Free()
+
+	if err = rewrite(h); err != nil {
+		tr.t.Fatalf("rewriting: %s", err)
+	}
+	if err = h.Override(); err != nil {
+		tr.t.Fatalf("overriding: %s", err)
+	}
+}
+
+// Compare two lists of strings, failing the test with name if they differ.
+func expectStrings(t *testing.T, name string, expected, res []string) {
+	if strings.Join(expected, "\n") != strings.Join(res, "\n") {
+		t.Errorf("%s: expected %q, got %q", name, expected, res)
+	}
+}
@@ -4,35 +4,146 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	git "gopkg.in/libgit2/git2go.v26"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
-const branchNameSize = 8
+const runIDSize = 8
 
-// Create a temporary branch using a randomly generated string. Returns
-// the reference of this branch to caller.
-func tmpBranch(repo *git.Repository, root *git.Commit) (*git.Reference, error) {
-	name := func() string { return SecureRandomString(branchNameSize) }
+// Namespace of the temporary references of rewrites, out of refs/heads/ so
+// they neither show up nor collide with branches.
+const tmpRefs = "refs/historiography/tmp/"
 
-	for { // we loop here just in case a branch with the same name exists
-		branch, err := repo.CreateBranch(name(), root, false)
-		if err == nil {
-			return branch.Reference, nil
+// Create the temporary reference of a rewrite, named after its run id and
+// pointing to root. Returns the reference to caller.
+func tmpBranch(repo *git.Repository, root *git.Commit, run string) (*git.Reference, error) {
+	return repo.References.Create(tmpRefs+run, root.Id(), false,
+		fmt.Sprintf("historiography: start (run %s)", run))
+}
+
+// Delete temporary references left by previous runs which crashed. Must be
+// called with the repository locked.
+func cleanTmpRefs(repo *git.Repository) error {
+	iterator, err := repo.NewReferenceIteratorGlob(tmpRefs + "*")
+	if err != nil {
+		return err
+	}
+	defer iterator.Free()
+
+	// references can not be changed while iterating on them
+	refs := []*git.Reference{}
+	defer func() {
+		for _, ref := range refs {
+			ref.Free()
+		}
+	}()
+	for {
+		ref, err := iterator.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		refs = append(refs, ref)
+	}
+
+	for _, ref := range refs {
+		glog.Warningf("deleting %s left by a previous run", ref.Name())
+		if err = ref.Delete(); err != nil {
+			return err
 		}
 	}
-	return nil, nil // this will never been reached
+	return nil
 }
 
+// Options driving the selection of commits to rewrite and the safety checks
+// performed before rewriting them.
+type Options struct {
+	// Number of latest commits to take into account, -1 means all of them.
+	Commits int
+	// Revision or revision range ending at HEAD (e.g: origin/master..HEAD)
+	// delimiting commits to rewrite. A single revision REV means REV..HEAD.
+	Range string
+	// Only take into account commits which are not pushed yet, i.e: stop the
+	// walk at the merge-base between HEAD and its upstream tracking branch.
+	Unpushed bool
+	// Allow rewriting commits reachable from a remote-tracking ref. Rewriting
+	// published commits is refused by default.
+	AllowPublished bool
+	// Select commits whose metadata (dates, identities, message) processers
+	// change, trees are processed on every commit. Nil means every commit is
+	// selected.
+	Filter Predicate
+	// Behaviour regarding commits with the same tree as their parent.
+	Empty EmptyMode
+	// Sign rewritten commits, nil leaves them unsigned.
+	Sign Signer
+	// Skip the verification of the rewritten history before overriding, see
+	// Historiography.Verify.
+	NoVerify bool
+	// Command line of the rewrite, recorded in its journal so it can be
+	// resumed with the same options.
+	Args []string
+	// Stash uncommitted changes before the rewrite and reapply them after it,
+	// a dirty working directory is refused otherwise.
+	AutoStash bool
+	// Resume the interrupted rewrite recorded in the journal, commits already
+	// replayed are not replayed again.
+	Resume bool
+}
+
+// Indicates which empty commits, i.e: commits with the same tree as their
+// parent, are dropped during the rewrite. Merge and root commits are kept.
+type EmptyMode int
+
+const (
+	// Keep every empty commit, default.
+	KeepEmpty EmptyMode = iota
+	// Drop commits which became empty because of the rewrite, commits which
+	// were empty before the rewrite are considered intentional and kept.
+	DropEmpty
+	// Drop every empty commit.
+	DropAllEmpty
+)
+
 // Historiography struct is responsible of creating and deleting a temporary
 // branch to perform commits change. It holds a reference of the HEAD branch
 // which will be overriden if a call to Override() is performed.
 type Historiography struct {
-	repo       *git.Repository
-	head       *git.Reference
-	tmp        *git.Reference
-	checkout   git.CheckoutOpts
-	cherrypick git.CherrypickOptions
-	processer  Processer
-	Commits    []Commits
+	repo      *git.Repository
+	head      *git.Reference
+	tmp       *git.Reference
+	checkout  git.CheckoutOpts
+	processer Processer
+	selected  map[git.Oid]bool
+	options   Options
+	Commits   []Commits
+	// Identifier of the rewrite, written in reflog entries.
+	RunID string
+	// Mapping from original commits to rewritten ones. Dropped commits are
+	// mapped to their rewritten parent.
+	Rewritten   map[git.Oid]git.Oid
+	skipped     map[git.Oid]bool // commits dropped by the plan
+	emptied     map[git.Oid]bool // commits dropped because empty
+	pending     map[git.Oid]bool // commits of the plan not replayed yet
+	order       map[git.Oid]int  // positions of commits in the original history
+	latest      int              // latest position of a commit picked, see order
+	reordered   bool             // a commit has been reordered by the plan
+	tip         *git.Oid         // last commit replayed
+	switched    bool             // HEAD detached on the tmp branch
+	moved       map[git.Oid]bool // commits whose first parent changed
+	verified    bool             // rewritten history checked, see Check
+	original    *git.Oid         // target of the overridden reference
+	resumed     map[git.Oid]bool // commits replayed before an interruption
+	journal     *Journal         // journal of the rewrite
+	progress    *os.File         // progress of the journal, nil once removed
+	lock        *repoLock        // lock of the repository
+	stashed     *git.Oid         // uncommitted changes stashed, see AutoStash
+	mutex       sync.Mutex       // guards interrupted, see Interrupt
+	interrupted bool
 }
 
 // Build a new Historiography struct, create branches, and hold references.
@@ -40,8 +151,14 @@ type Historiography struct {
 // For the moment the object hold reference from head, in the future we'd like
 // to configure the branch from which the temporary branch is created and which
 // will be overriden.
-func NewHistoriography(repo *git.Repository, p Processer, nb int) (h *Historiography, err error) {
-	h = &Historiography{repo: repo, processer: p}
+func NewHistoriography(repo *git.Repository, p Processer, opts *Options) (h *Historiography, err error) {
+	h = &Historiography{repo: repo, processer: p, options: *opts}
+	h.RunID = SecureRandomString(runIDSize)
+	h.Rewritten = map[git.Oid]git.Oid{}
+	h.skipped = map[git.Oid]bool{}
+	h.emptied = map[git.Oid]bool{}
+	h.resumed = map[git.Oid]bool{}
+	h.moved = map[git.Oid]bool{}
 	h.checkout = git.CheckoutOpts{Strategy: git.CheckoutForce}
 
 	// non-clean repositories can be dangerous to operate, cancel and raise error
@@ -49,29 +166,116 @@ func NewHistoriography(repo *git.Repository, p Processer, nb int) (h *Historiogr
 		return nil, fmt.Errorf("repository is not in a clear state")
 	}
 
-	if h.cherrypick, err = git.DefaultCherrypickOptions(); err != nil {
+	// a single rewrite at a time, the lock is released by Free
+	lock, err := lockRepository(repo, h.RunID)
+	if err != nil {
 		return
 	}
+	h.lock = lock
+	defer func() {
+		if err != nil {
+			lock.release()
+		}
+	}()
 
 	// save ref of HEAD
 	if h.head, err = h.repo.Head(); err != nil {
 		return
 	}
 
-	if h.Commits, err = Retrieve(repo, nb); err != nil {
+	// an interrupted rewrite has to be resumed or rolled back first
+	journal, err := ReadJournal(repo)
+	if err != nil {
+		return
+	}
+	switch {
+	case journal != nil && !opts.Resume:
+		return nil, fmt.Errorf("a rewrite is in progress (run %s), continue or abort it", journal.RunID)
+	case journal == nil && opts.Resume:
+		return nil, fmt.Errorf("no rewrite to resume")
+	case journal != nil && journal.Target != h.head.Target().String():
+		return nil, fmt.Errorf("%s moved since the rewrite was interrupted", journal.Head)
+	case journal != nil:
+		h.RunID = journal.RunID
+		if err = lock.hold(h.RunID); err != nil {
+			return
+		}
+	}
+
+	// content changes apply to every commit, commits preceding the selection
+	// are only skipped if trees are left untouched
+	retrieve := *opts
+	if ChangesTrees(p) {
+		retrieve.Filter = nil
+	}
+	if h.Commits, err = Retrieve(repo, &retrieve); err != nil {
+		return
+	}
+
+	// refuse to rewrite commits other people may have fetched already
+	if !opts.AllowPublished {
+		var commit *git.Commit
+		if commit, err = Published(repo, Flatten(h.Commits)); err != nil {
+			return
+		}
+		if commit != nil {
+			return nil, fmt.Errorf(
+				"commit %s is reachable from a remote-tracking ref, refusing to rewrite it",
+				commit.Id().String()[:10])
+		}
+	}
+
+	// remember commits selected by the filter, nil meaning all of them
+	if opts.Filter != nil {
+		if h.selected, err = selection(Flatten(h.Commits), opts.Filter); err != nil {
+			return
+		}
+	}
+
+	// create tmp branch, once leftovers of crashed runs are gone
+	if err = cleanTmpRefs(repo); err != nil {
+		return
+	}
+	if h.tmp, err = tmpBranch(repo, h.Commits[0][0], h.RunID); err != nil {
+		return
+	}
+
+	// keep uncommitted changes away from the checkout
+	if err = h.stash(); err != nil {
+		h.Free()
 		return
 	}
 
-	// create tmp branch
-	if h.tmp, err = tmpBranch(repo, h.Commits[0][0]); err != nil {
+	// record the rewrite, free the struct in case of an error
+	if opts.Resume {
+		if err = h.resume(); err != nil {
+			h.Free()
+			return
+		}
+		if err = h.adoptStash(journal); err != nil {
+			h.Free()
+			return
+		}
+	}
+	h.journal = &Journal{opts.Args, h.RunID, h.head.Name(), h.head.Target().String(), h.tmp.Name(), nil, ""}
+	if journal != nil {
+		h.journal.Schedule = journal.Schedule
+	}
+	if h.stashed != nil {
+		h.journal.Stash = h.stashed.String()
+	}
+	if h.progress, err = h.journal.write(repo, opts.Resume); err != nil {
+		h.Free()
 		return
 	}
 
-	// switch to tmp branch, free the struct in case of an error
-	if err = h.repo.SetHead(h.tmp.Name()); err != nil {
+	// switch to tmp branch, free the struct in case of an error. It is not a
+	// branch, HEAD is detached on its target.
+	if err = h.repo.SetHeadDetached(h.tmp.Target()); err != nil {
 		h.Free()
 		return
 	}
+	h.switched = true
 	if err = h.repo.CheckoutHead(&h.checkout); err != nil {
 		h.Free()
 		return
@@ -80,45 +284,131 @@ func NewHistoriography(repo *git.Repository, p Processer, nb int) (h *Historiogr
 	return
 }
 
-// Override the saved reference with the temporary branch.
-func (h *Historiography) Override() error {
+// Verify the rewritten history before overriding, see Verify. Override checks
+// it unless already done, so that several rewrites can be checked before any of
+// them is overridden.
+func (h *Historiography) Check() error {
+	if h.Interrupted() {
+		return ErrInterrupted
+	}
+	if h.options.NoVerify || h.verified {
+		return nil
+	}
 
-	// retrieve last commit from tmp branch
-	commit, err := h.repo.LookupCommit(h.tmp.Target())
+	// content and structure must survive a rewrite of metadata
+	diffs, err := h.Verify()
 	if err != nil {
 		return err
 	}
+	for _, diff := range diffs {
+		glog.Errorf("%s", diff)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("rewritten history differs from the original one in %d places (%s)",
+			len(diffs), diffs[0])
+	}
+	h.verified = true
+	return nil
+}
 
-	// get saved reference branch name
-	branch, err := h.head.Branch().Name()
-	if err != nil {
+// Override the saved reference with the temporary branch. The journal is
+// removed once done, the rewrite can not be resumed anymore.
+func (h *Historiography) Override() error {
+	if err := h.Check(); err != nil {
+		return err
+	}
+	if err := h.point(h.tmp.Target(), h.reflog("rewrite", h.head.Target())); err != nil {
 		return err
 	}
 
-	// override branch by forcing creation of a new branch with same name
-	_, err = h.repo.CreateBranch(branch, commit, true)
-	return err
+	h.progress.Close()
+	h.progress = nil
+	return removeJournal(h.repo)
 }
 
-// Delete tmp branch if still present and checkout saved ref.
-func (h *Historiography) del() (err error) {
+// Move the overridden reference back to its original commit, e.g: when
+// rewrites overridden together can not all be overridden.
+func (h *Historiography) Revert() error {
+	if h.original == nil {
+		return nil // not overridden
+	}
+	if err := h.point(h.original, h.reflog("revert", h.head.Target())); err != nil {
+		return err
+	}
+	h.original = nil
+	return nil
+}
+
+// Point the saved reference to a commit, HEAD itself if it was detached.
+func (h *Historiography) point(oid *git.Oid, msg string) (err error) {
+	original := h.head.Target()
 	var ref *git.Reference
-	if h.tmp == nil {
+	if h.detached() {
+		if err = h.repo.SetHeadDetached(oid); err != nil {
+			return
+		}
+		ref, err = h.repo.Head()
+	} else {
+		// the reflog entry tells what happened and where the branch was
+		ref, err = h.repo.References.Create(h.head.Name(), oid, true, msg)
+	}
+	if err != nil {
 		return
 	}
-	if ref, err = h.tmp.Resolve(); err != nil {
-		return nil // branch does not exist anymore, abort
+	if h.original == nil {
+		h.original = original
 	}
-	if err = h.repo.SetHead(h.head.Name()); err != nil {
-		return
+	h.head.Free()
+	h.head = ref
+	return
+}
+
+// Indicates if HEAD was detached when the rewrite started.
+func (h *Historiography) detached() bool {
+	return h.head.Name() == "HEAD"
+}
+
+// Reflog message of a reference update made by the rewrite, e.g:
+// "historiography: rewrite, 12 commits changed by DateProcessor, NameProcessor
+// (run 1a2b3c4d), was 5e4d3c2b1a".
+func (h *Historiography) reflog(action string, old *git.Oid) string {
+	changed := 0
+	for _, commit := range Flatten(h.Commits) {
+		if h.resolve(*commit.Id()) != *commit.Id() {
+			changed++
+		}
 	}
-	if err = h.repo.CheckoutHead(&h.checkout); err != nil {
+	return fmt.Sprintf("historiography: %s, %d commits changed by %s (run %s), was %s",
+		action, changed, ProcesserName(h.processer), h.RunID, old.String()[:10])
+}
+
+// Delete tmp branch if still present and checkout saved ref.
+func (h *Historiography) del() (err error) {
+	var ref *git.Reference
+	if h.tmp == nil {
 		return
 	}
-	if err = ref.Delete(); err != nil {
-		return
+	// the working directory is only checked out if we switched to tmp branch,
+	// it may hold uncommitted changes otherwise
+	if h.switched {
+		if h.detached() {
+			err = h.repo.SetHeadDetached(h.head.Target())
+		} else {
+			err = h.repo.SetHead(h.head.Name())
+		}
+		if err != nil {
+			return
+		}
+		if err = h.repo.CheckoutHead(&h.checkout); err != nil {
+			return
+		}
+		h.switched = false
 	}
-	return
+	if ref, err = h.tmp.Resolve(); err != nil {
+		return nil // branch does not exist anymore
+	}
+	defer ref.Free()
+	return ref.Delete()
 }
 
 // Free resources from libgit. Clean repository by deleting tmp branch.
@@ -132,94 +422,763 @@ func (h *Historiography) Free() {
 	}
 
 	h.tmp.Free()
+
+	if err := h.unstash(); err != nil {
+		glog.Errorf("%s", err)
+	}
+
+	// the journal of an interrupted rewrite is kept to resume it
+	if h.progress != nil {
+		h.progress.Close()
+		if !h.Interrupted() {
+			if err := removeJournal(h.repo); err != nil {
+				glog.Errorf("removing journal failed: %s", err)
+			}
+		}
+	}
+
+	if err := h.lock.release(); err != nil {
+		glog.Errorf("releasing repository lock failed: %s", err)
+	}
+}
+
+// Restore progress of the interrupted rewrite from the journal.
+func (h *Historiography) resume() error {
+	steps, err := readProgress(h.repo)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		h.resumed[step.old] = true
+		if step.mapped {
+			h.Rewritten[step.old] = step.new
+		}
+		switch step.action {
+		case "drop":
+			h.skipped[step.old] = true
+		case "empty":
+			h.emptied[step.old] = true
+		case "move":
+			h.moved[step.old] = true
+		}
+	}
+	return nil
+}
+
+// Append a replayed commit to the progress of the journal, new is nil if the
+// commit is not mapped.
+func (h *Historiography) record(action string, old, new *git.Oid) error {
+	mapped := "-"
+	if new != nil {
+		mapped = new.String()
+	}
+	_, err := fmt.Fprintf(h.progress, "%s %s %s\n", action, old, mapped)
+	return err
+}
+
+// Stop the rewrite, safe to call from another goroutine (e.g: a signal
+// handler). Only a flag is set: a replay stops before the next commit and
+// Override refuses to run, both returning ErrInterrupted, then Free keeps the
+// journal so the rewrite can be resumed.
+func (h *Historiography) Interrupt() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.interrupted = true
+}
+
+// Indicates if the rewrite has been interrupted, see Interrupt.
+func (h *Historiography) Interrupted() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.interrupted
 }
 
 // Apply commit on top of the tmp branch, if commit appear in changes, date
-// will be updated.
+// will be updated. The commit is created directly from its (processed) tree
+// with its parents mapped to their rewritten counterparts, the working
+// directory is not involved.
 func (h *Historiography) Apply(commit *git.Commit) error {
-	err := h.repo.Cherrypick(commit, h.cherrypick)
+	return h.apply(Step{Pick, commit, nil})
+}
+
+// Replay a step of a plan, see Replay.
+func (h *Historiography) apply(step Step) (err error) {
+	commit := step.Commit
+	delete(h.pending, *commit.Id())
+
+	// once a commit is picked after one originally following it, it and every
+	// following commit are replayed on top of the previous step, like
+	// git rebase -i does
+	if pos, ok := h.order[*commit.Id()]; ok && step.Action == Pick {
+		h.reordered = h.reordered || pos < h.latest
+		if pos > h.latest {
+			h.latest = pos
+		}
+	}
+
+	// replayed before the interruption, only move the tmp branch
+	if h.resumed[*commit.Id()] {
+		if h.skipped[*commit.Id()] {
+			return nil
+		}
+		id := h.resolve(*commit.Id())
+		return h.setTmp(&id)
+	}
+
+	if step.Action == Drop {
+		return h.drop(commit)
+	}
+
+	m, a, c, t, err := h.getArgs(commit)
 	if err != nil {
-		return err
+		return
 	}
+	defer func() { t.Free() }()
 
-	r, m, a, c, t, err := h.getArgs(commit)
+	ids := step.Parents
+	if ids == nil {
+		ids = parentIds(commit)
+		// plan reordered, moved on top of the previous step
+		if len(ids) > 0 && h.tip != nil && h.reordered {
+			ids[0] = *h.tip
+		}
+	}
+	parents, err := h.parents(commit, ids)
 	if err != nil {
-		return err
+		return
+	}
+	defer func() {
+		for _, parent := range parents {
+			parent.Free()
+		}
+	}()
+
+	// moved by the plan, and not only on top of a dropped parent
+	action := "pick"
+	if !sameParents(h.mappedParents(commit), parents) {
+		h.moved[*commit.Id()], action = true, "move"
+	}
+	// first parent changed, changes of the commit have to be merged
+	if h.rebased(commit, parents) {
+		var merged *git.Tree
+		if merged, err = h.merge(commit, parents, t, true); err != nil {
+			return
+		}
+		t.Free()
+		t = merged
 	}
 
-	parent, err := h.repo.LookupCommit(h.tmp.Target())
+	if h.dropped(commit, commit, t, parents) {
+		h.emptied[*commit.Id()] = true
+		h.Rewritten[*commit.Id()] = *parents[0].Id()
+		if err = h.record("empty", commit.Id(), parents[0].Id()); err != nil {
+			return
+		}
+		return h.setTmp(parents[0].Id())
+	}
+
+	id, err := h.create(commit, a, c, m, t, parents)
 	if err != nil {
+		return
+	}
+	h.Rewritten[*commit.Id()] = *id
+	if err = h.record(action, commit.Id(), id); err != nil {
+		return
+	}
+	return h.setTmp(id)
+}
+
+// Create a commit without updating any reference. The commit is signed if a
+// signer is configured, the signature is computed on the commit content and
+// stored in its gpgsig header. Headers of the original commit are kept, except
+// the omitted ones.
+func (h *Historiography) create(origin *git.Commit, a, c *git.Signature, m string,
+	t *git.Tree, parents []*git.Commit, omit ...string) (*git.Oid, error) {
+
+	ids := make([]*git.Oid, len(parents))
+	for i, parent := range parents {
+		ids[i] = parent.Id()
+	}
+	all, err := h.headers(origin, ids)
+	if err != nil {
+		return nil, err
+	}
+	headers := []header{}
+	for _, hd := range all {
+		if !hasKey(omit, hd.Key) {
+			headers = append(headers, hd)
+		}
+	}
+	if h.options.Sign == nil && len(headers) == 0 {
+		return h.repo.CreateCommit("", a, c, m, t, parents...)
+	}
+
+	if h.options.Sign != nil {
+		signature, err := h.options.Sign.Sign(formatCommit(t.Id(), ids, a, c, headers, m))
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, header{"gpgsig", strings.TrimRight(signature, "\n")})
+	}
+	return writeCommit(h.repo, formatCommit(t.Id(), ids, a, c, headers, m))
+}
+
+// Headers of the original commit carried over to the rewritten one (e.g:
+// encoding or custom headers). Standard headers are rebuilt, signatures are
+// invalidated by the rewrite and merge tags only hold if the tagged commit is
+// still a parent.
+func (h *Historiography) headers(origin *git.Commit, parents []*git.Oid) (res []header, err error) {
+	headers, err := readHeaders(h.repo, origin.Id())
+	if err != nil {
+		return
+	}
+	for _, hd := range headers {
+		switch hd.Key {
+		case "tree", "parent", "author", "committer":
+			continue
+		case "mergetag":
+			if !tagsParent(hd.Value, parents) {
+				continue
+			}
+		}
+		if !signature(hd) {
+			res = append(res, hd)
+		}
+	}
+	return
+}
+
+// Indicates if the tag embedded in a mergetag header points to a parent.
+func tagsParent(tag string, parents []*git.Oid) bool {
+	line := strings.SplitN(tag, "\n", 2)[0]
+	if !strings.HasPrefix(line, "object ") {
+		return false
+	}
+	for _, parent := range parents {
+		if parent.String() == strings.TrimPrefix(line, "object ") {
+			return true
+		}
+	}
+	return false
+}
+
+// Drop a commit from the plan, commits based on it are replayed on its
+// rewritten parent.
+func (h *Historiography) drop(commit *git.Commit) error {
+	h.skipped[*commit.Id()] = true
+	var mapped *git.Oid
+	if commit.ParentCount() > 0 {
+		h.Rewritten[*commit.Id()] = h.resolve(*commit.ParentId(0))
+		mapped = commit.ParentId(0)
+	}
+	if err := h.record("drop", commit.Id(), mapped); err != nil {
 		return err
 	}
+	if h.tip == nil {
+		return nil
+	}
+	return h.setTmp(h.tip)
+}
+
+// Indicates if commits are the given parents, in the same order.
+func sameParents(ids []git.Oid, parents []*git.Commit) bool {
+	if len(ids) != len(parents) {
+		return false
+	}
+	for i, parent := range parents {
+		if !parent.Id().Equal(&ids[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Ids of the parents of a commit.
+func parentIds(commit *git.Commit) (ids []git.Oid) {
+	for i := uint(0); i < commit.ParentCount(); i++ {
+		ids = append(ids, *commit.ParentId(i))
+	}
+	return
+}
+
+// Follow the mapping of rewritten commits, dropped commits can be mapped to
+// commits which are rewritten later.
+func (h *Historiography) resolve(oid git.Oid) git.Oid {
+	for i := 0; i <= len(h.Rewritten); i++ {
+		next, ok := h.Rewritten[oid]
+		if !ok || next == oid {
+			break
+		}
+		oid = next
+	}
+	return oid
+}
+
+// Mapping from original commits to the commits they are rewritten as, e.g: to
+// update references to them from other repositories. Unchanged commits are not
+// listed.
+func (h *Historiography) Mapping() map[git.Oid]git.Oid {
+	mapping := map[git.Oid]git.Oid{}
+	for oid := range h.Rewritten {
+		if resolved := h.resolve(oid); resolved != oid {
+			mapping[oid] = resolved
+		}
+	}
+	return mapping
+}
+
+// Lookup rewritten parents of a commit, parents which are not rewritten are
+// kept. A first parent not replayed yet is replaced by the last commit
+// replayed. Parents mapped to the same commit (because of dropped commits)
+// are only kept once.
+func (h *Historiography) parents(commit *git.Commit, ids []git.Oid) (parents []*git.Commit, err error) {
+	seen := map[git.Oid]bool{}
+	for i, id := range ids {
+		oid := h.resolve(id)
+		if _, ok := h.Rewritten[oid]; !ok && h.skipped[oid] {
+			continue // dropped root commit
+		}
+		if h.pending[oid] {
+			if i > 0 {
+				return nil, fmt.Errorf("parent %s of commit %s is replayed after it",
+					id.String()[:10], commit.Id().String()[:10])
+			}
+			if h.tip == nil {
+				continue
+			}
+			oid = *h.tip
+		}
+		if seen[oid] {
+			continue
+		}
+		seen[oid] = true
+
+		var parent *git.Commit
+		if parent, err = h.repo.LookupCommit(&oid); err != nil {
+			return
+		}
+		parents = append(parents, parent)
+	}
+	return
+}
+
+// Indicates if the first parent of a commit is not its rewritten original
+// first parent anymore, i.e: the commit has been moved.
+func (h *Historiography) rebased(commit *git.Commit, parents []*git.Commit) bool {
+	if commit.ParentCount() == 0 {
+		return len(parents) > 0
+	}
+	orig := *commit.ParentId(0)
+	if h.skipped[orig] || h.pending[orig] || len(parents) == 0 {
+		return true
+	}
+	expected := orig
+	if rewritten, ok := h.Rewritten[orig]; ok {
+		expected = rewritten
+	}
+	return !parents[0].Id().Equal(&expected)
+}
+
+// Merge changes of a commit, i.e: its (processed) tree against the one of its
+// original first parent, on top of its new first parent, as git cherry-pick
+// does. The tree of the original parent is processed if process is true, as
+// the tree of the commit is. Returns a *ConflictError if changes do not apply.
+func (h *Historiography) merge(commit *git.Commit, parents []*git.Commit, tree *git.Tree,
+	process bool) (*git.Tree, error) {
+	var ancestor, ours *git.Tree
+	var err error
+	if commit.ParentCount() > 0 {
+		parent := commit.Parent(0)
+		defer parent.Free()
+		if ancestor, err = h.treeOf(parent, process); err != nil {
+			return nil, err
+		}
+	} else if ancestor, err = h.empty(); err != nil {
+		return nil, err
+	}
+	defer ancestor.Free()
+
+	if len(parents) > 0 {
+		ours, err = parents[0].Tree()
+	} else {
+		ours, err = h.empty()
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer ours.Free()
+
+	index, err := h.repo.MergeTrees(ancestor, ours, tree, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Free()
+
+	if index.HasConflicts() {
+		return nil, conflict(commit, index)
+	}
+	oid, err := index.WriteTreeTo(h.repo)
+	if err != nil {
+		return nil, err
+	}
+	return h.repo.LookupTree(oid)
+}
 
-	_, err = h.repo.CreateCommit(r, a, c, m, t, parent)
+// Create and lookup the empty tree.
+func (h *Historiography) empty() (*git.Tree, error) {
+	builder, err := h.repo.TreeBuilder()
+	if err != nil {
+		return nil, err
+	}
+	defer builder.Free()
+	oid, err := builder.Write()
+	if err != nil {
+		return nil, err
+	}
+	return h.repo.LookupTree(oid)
+}
+
+// Build the error describing conflicts of a merge.
+func conflict(commit *git.Commit, index *git.Index) error {
+	res := &ConflictError{Commit: *commit.Id()}
+	iterator, err := index.ConflictIterator()
 	if err != nil {
 		return err
 	}
+	defer iterator.Free()
 
-	return h.updateTmpRef()
+	for {
+		c, err := iterator.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			return res
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range []*git.IndexEntry{c.Our, c.Their, c.Ancestor} {
+			if entry != nil {
+				res.Paths = append(res.Paths, entry.Path)
+				break
+			}
+		}
+	}
+}
+
+// Indicates if a commit, or commits from first to last squashed together, has
+// to be dropped according to the empty mode, i.e: its rewritten tree equals the
+// tree of its rewritten parent.
+func (h *Historiography) dropped(first, last *git.Commit, tree *git.Tree, parents []*git.Commit) bool {
+	if h.options.Empty == KeepEmpty || first.ParentCount() != 1 || len(parents) != 1 {
+		return false
+	}
+	if !parents[0].TreeId().Equal(tree.Id()) {
+		return false
+	}
+	if h.options.Empty == DropAllEmpty {
+		return true
+	}
+	// commits empty before the rewrite are intentional
+	parent := first.Parent(0)
+	defer parent.Free()
+	return !parent.TreeId().Equal(last.TreeId())
 }
 
 // Each time a commit is applied on tmp branch we have to update our internal
 // reference.
-func (h *Historiography) updateTmpRef() error {
-	ref, err := h.tmp.Resolve()
+func (h *Historiography) setTmp(oid *git.Oid) error {
+	ref, err := h.tmp.SetTarget(oid, fmt.Sprintf("historiography: apply commit (run %s)", h.RunID))
 	if err != nil {
 		return err
 	}
 	h.tmp.Free()
-	h.tmp = ref
+	h.tmp, h.tip = ref, oid
 	return nil
 }
 
-// Use embedded processer preprocess function on each day with a list of commits.
-func (h *Historiography) Preprocess(commits []Commits) error {
+// Evaluate predicate on each commit, returns the set of matching commits.
+func selection(commits Commits, predicate Predicate) (map[git.Oid]bool, error) {
+	selected := map[git.Oid]bool{}
 	for _, commit := range commits {
-		if err := h.processer.Preprocess(commit); err != nil {
+		ok, err := predicate.Match(commit)
+		if err != nil {
+			return nil, err
+		}
+		selected[*commit.Id()] = ok
+	}
+	return selected, nil
+}
+
+// Indicates if the embedded processer has to be applied on the commit.
+func (h *Historiography) Selected(commit *git.Commit) bool {
+	return h.selected == nil || h.selected[*commit.Id()]
+}
+
+// Use embedded processer preprocess function on each day with a list of
+// commits. Only selected commits are passed down to the processer.
+func (h *Historiography) Preprocess(commits []Commits) error {
+	for _, day := range commits {
+		selected := Commits{}
+		for _, commit := range day {
+			if h.Selected(commit) {
+				selected = append(selected, commit)
+			}
+		}
+		if len(selected) == 0 {
+			continue
+		}
+		if err := h.processer.Preprocess(selected); err != nil {
 			return err
 		}
 	}
-	return nil
+	return h.schedule()
+}
+
+// Run Preprocess on the commits squashed groups are published as, see Squash:
+// the last commit of each group, whose dates squashed commits take, grouped by
+// day. Groups must be given parents first.
+func (h *Historiography) PreprocessGroups(groups []Commits) error {
+	days := []Commits{}
+	var year, day int
+	var month time.Month
+	for _, group := range groups {
+		last := group[len(group)-1]
+		date := last.Author().When
+		if y, m, d := date.Date(); len(days) == 0 || y != year || m != month || d != day {
+			days, year, month, day = append(days, Commits{}), y, m, d
+		}
+		days[len(days)-1] = append(days[len(days)-1], last)
+	}
+	return h.Preprocess(days)
+}
+
+// Save dates decided by schedulers in the journal or, when resuming, restore
+// the ones of the interrupted rewrite, see Scheduler.
+func (h *Historiography) schedule() error {
+	if h.options.Resume && h.journal.Schedule != nil {
+		dates := map[git.Oid]time.Time{}
+		for id, date := range h.journal.Schedule {
+			oid, err := git.NewOid(id)
+			if err != nil {
+				return fmt.Errorf("invalid journal: %s", err)
+			}
+			dates[*oid] = date
+		}
+		for _, s := range schedulers(h.processer) {
+			schedule := s.Schedule()
+			for oid := range schedule {
+				delete(schedule, oid)
+			}
+			for oid, date := range dates {
+				schedule[oid] = date
+			}
+		}
+		return nil
+	}
+
+	h.journal.Schedule = map[string]time.Time{}
+	for _, s := range schedulers(h.processer) {
+		for oid, date := range s.Schedule() {
+			h.journal.Schedule[oid.String()] = date
+		}
+	}
+	return h.journal.save(h.repo)
 }
 
 // Utilitary function which returns well formated arguments for creating commits.
 func (h *Historiography) getArgs(commit *git.Commit) (
-	r, m string, a, c *git.Signature, t *git.Tree, e error,
+	m string, a, c *git.Signature, t *git.Tree, e error,
 ) {
-	// retrieve informations from old commit.
-	r = h.tmp.Name()
+	if m, a, c, e = h.metadata(commit); e != nil {
+		return
+	}
+	t, e = h.tree(commit)
+	return
+}
 
-	a, c, m, e = h.processer.Process(commit)
+// Returns message, author and committer of a commit, changed by the embedded
+// processer if the commit is selected.
+func (h *Historiography) metadata(commit *git.Commit) (
+	m string, a, c *git.Signature, e error,
+) {
+	// commits out of the selection are replayed untouched
+	if !h.Selected(commit) {
+		return commit.RawMessage(), commit.Author(), commit.Committer(), nil
+	}
+
+	// messages in legacy encodings are filtered as UTF-8 then encoded back
+	enc, e := messageEncoding(h.repo, commit)
 	if e != nil {
 		return
 	}
+	composer, ok := h.processer.(*ComposerProcessor)
+	if !ok {
+		composer = &ComposerProcessor{[]Processer{h.processer}}
+	}
+	a, c, m, e = composer.process(commit, enc)
+	return
+}
+
+// Returns the tree of a commit, changed by the embedded processer if it is a
+// TreeProcesser. Selection does not apply: content removed from history has to
+// be removed from every commit.
+func (h *Historiography) tree(commit *git.Commit) (*git.Tree, error) {
+	return h.treeOf(commit, true)
+}
+
+// Returns the tree of a commit, changed by the embedded processer if it is a
+// TreeProcesser and process is true.
+func (h *Historiography) treeOf(commit *git.Commit, process bool) (t *git.Tree, e error) {
+	if t, e = commit.Tree(); e != nil {
+		return
+	}
 
-	t, e = commit.Tree()
+	// let processers able to rewrite content change the tree
+	if tp, ok := h.processer.(TreeProcesser); ok && process {
+		t, e = tp.ProcessTree(commit, t)
+	}
 	return
 }
 
 // Play commits on top of the temporary branch, embedded processer is called in
-// order to furnish informations for the new commit.
-func (h *Historiography) Process(commits Commits) (err error) {
-	root := commits[0]
-
-	r, m, a, c, t, err := h.getArgs(root)
+// order to furnish informations for the new commit. Commits must be given
+// parents first, as retrieved. If the embedded processer is a Planner, the plan
+// it returns is replayed instead.
+func (h *Historiography) Process(commits Commits) error {
+	plan, err := h.Plan(commits)
 	if err != nil {
-		return
+		return err
 	}
+	return h.Replay(plan)
+}
 
-	if _, err = root.Amend(r, a, c, m, t); err != nil {
-		return
+// Build the plan of commits, changed by the embedded processer if it is a
+// Planner.
+func (h *Historiography) Plan(commits Commits) (Plan, error) {
+	plan := NewPlan(commits)
+	if planner, ok := h.processer.(Planner); ok {
+		return planner.Plan(plan)
 	}
+	return plan, nil
+}
 
-	if err = h.updateTmpRef(); err != nil {
-		return
+// Replay a plan on top of the temporary branch. Steps are replayed in order,
+// a commit whose first parent is dropped, or replayed after it, is replayed on
+// top of the last commit replayed: its changes are merged and a *ConflictError
+// is returned if they do not apply. Once a commit is replayed after a commit
+// originally following it, every following commit is replayed that way.
+func (h *Historiography) Replay(plan Plan) (err error) {
+	h.pending = map[git.Oid]bool{}
+	for _, step := range plan {
+		h.pending[*step.Commit.Id()] = true
 	}
+	h.order, h.latest, h.reordered = map[git.Oid]int{}, -1, false
+	for i, commit := range Flatten(h.Commits) {
+		h.order[*commit.Id()] = i
+	}
+	defer func() { h.pending, h.order = nil, nil }()
 
-	for _, commit := range commits[1:] {
-		if err = h.Apply(commit); err != nil {
+	// commits moved first are replayed on the base of the rewrite
+	if h.tip == nil && len(plan) > 0 {
+		first := Flatten(h.Commits)[0]
+		if first.ParentCount() > 0 {
+			h.tip = first.ParentId(0)
+		}
+	}
+
+	for _, step := range plan {
+		if h.Interrupted() {
+			return ErrInterrupted
+		}
+		if err = h.apply(step); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Squash each group of commits into a single commit on top of the temporary
+// branch. A squashed commit takes the (processed) tree, author and committer
+// of the last commit of its group, and the parents of the first one. Its
+// message lists messages of the group, see SquashMessage. Groups must be
+// given parents first, as retrieved, merges inside a group are flattened.
+func (h *Historiography) Squash(groups []Commits) (err error) {
+	for _, group := range groups {
+		if h.Interrupted() {
+			return ErrInterrupted
+		}
+		if len(group) == 1 {
+			err = h.Apply(group[0])
+		} else {
+			err = h.squash(group)
+		}
+		if err != nil {
 			return
 		}
 	}
 	return
 }
+
+// Squash a group of commits, see Squash.
+func (h *Historiography) squash(group Commits) error {
+	last := group[len(group)-1]
+	if h.resumed[*last.Id()] {
+		id := h.resolve(*last.Id())
+		return h.setTmp(&id)
+	}
+
+	// messages are joined in UTF-8, whatever their encoding
+	messages := make([]string, len(group))
+	for i, commit := range group {
+		m, _, _, err := h.metadata(commit)
+		if err != nil {
+			return err
+		}
+		if messages[i], err = decodeMessage(h.repo, commit, m); err != nil {
+			return err
+		}
+	}
+
+	_, a, c, err := h.metadata(last)
+	if err != nil {
+		return err
+	}
+	t, err := h.tree(last)
+	if err != nil {
+		return err
+	}
+	defer t.Free()
+
+	parents, err := h.parents(group[0], parentIds(group[0]))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, parent := range parents {
+			parent.Free()
+		}
+	}()
+
+	// the group as a whole is empty, its commits are dropped
+	if h.dropped(group[0], last, t, parents) {
+		for _, commit := range group {
+			h.emptied[*commit.Id()] = true
+			h.Rewritten[*commit.Id()] = *parents[0].Id()
+			if err = h.record("empty", commit.Id(), parents[0].Id()); err != nil {
+				return err
+			}
+		}
+		return h.setTmp(parents[0].Id())
+	}
+
+	id, err := h.create(last, a, c, SquashMessage(messages), t, parents, "encoding")
+	if err != nil {
+		return err
+	}
+	for _, commit := range group {
+		h.Rewritten[*commit.Id()] = *id
+		if err = h.record("pick", commit.Id(), id); err != nil {
+			return err
+		}
+	}
+	return h.setTmp(id)
+}
@@ -0,0 +1,80 @@
+package historiography
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSquash(t *testing.T) {
+	tests := []struct {
+		empty    EmptyMode
+		expected []string
+	}{
+		{KeepEmpty, []string{"base", "Squash 3 commits", "Squash 2 commits", "lone"}},
+		// the group was already empty before the rewrite
+		{DropEmpty, []string{"base", "Squash 3 commits", "Squash 2 commits", "lone"}},
+		{DropAllEmpty, []string{"base", "Squash 3 commits", "lone"}},
+	}
+
+	for _, test := range tests {
+		tr := newTestRepo(t)
+		day := 24 * time.Hour
+		tr.commit(testDate, "base", "a", "1")
+		tr.commit(testDate.Add(day), "one", "b", "1")
+		tr.commit(testDate.Add(day+time.Hour), "two", "b", "2")
+		tr.commit(testDate.Add(day+2*time.Hour), "three", "c", "1")
+		// changes of the second day cancel out
+		tr.commit(testDate.Add(2*day), "four", "d", "1")
+		tr.commit(testDate.Add(2*day+time.Hour), "five", "d", "")
+		tr.commit(testDate.Add(3*day), "lone", "e", "1")
+		tree := tr.git("rev-parse", "HEAD^{tree}")
+
+		tr.rewrite(&ComposerProcessor{}, &Options{Commits: -1, Range: "master~6", Empty: test.empty},
+			func(h *Historiography) error { return h.Squash(h.Commits) })
+
+		name := fmt.Sprintf("empty mode %d", test.empty)
+		expectStrings(t, name, test.expected, tr.subjects("HEAD"))
+		expectStrings(t, name+" tree", []string{tree}, []string{tr.git("rev-parse", "HEAD^{tree}")})
+
+		// squashed commits take dates of the last commit of their group
+		rev := fmt.Sprintf("master~%d", len(test.expected)-2)
+		date := fmt.Sprint(testDate.Add(day + 2*time.Hour).Unix())
+		expectStrings(t, name+" dates", []string{date, date}, []string{
+			tr.git("log", "-1", "--format=%ad", "--date=unix", rev),
+			tr.git("log", "-1", "--format=%cd", "--date=unix", rev),
+		})
+		expectStrings(t, name+" message", []string{"Squash 3 commits\n\n* one\n* two\n* three"},
+			[]string{tr.git("log", "-1", "--format=%B", rev)})
+
+		tr.Free()
+	}
+}
+
+func TestRemovePathSelection(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.Free()
+	ids := planHistory(tr)
+
+	// only the last commits are selected, b has to be removed from older ones too
+	opts := &Options{Commits: -1, Range: ids["base"], Filter: &DatePredicate{Since: testDate.Add(3)}}
+	tr.rewrite(&RemovePathsProcessor{Repo: tr.repo, Patterns: []string{"b"}}, opts,
+		func(h *Historiography) error { return h.Process(Flatten(h.Commits)) })
+
+	expectStrings(t, "subjects", []string{"base", "one", "two", "three", "four"}, tr.subjects("HEAD"))
+	expectStrings(t, "commits changing b", []string{""}, []string{tr.git("log", "--format=%s", "HEAD", "--", "b")})
+}
+
+func TestBlobSize(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.Free()
+	base := tr.commit(testDate, "base", "small", "1")
+	tr.commit(testDate.Add(1), "big", "big", "0123456789")
+	// the same blob again, its size is cached
+	tr.commit(testDate.Add(2), "copy", "copy", "0123456789")
+
+	tr.rewrite(&BlobSizeProcessor{Repo: tr.repo, Limit: 4}, &Options{Commits: -1, Range: base},
+		func(h *Historiography) error { return h.Process(Flatten(h.Commits)) })
+
+	expectStrings(t, "files", []string{"small"}, []string{tr.git("ls-tree", "--name-only", "HEAD")})
+}
@@ -0,0 +1,205 @@
+package historiography
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	git "gopkg.in/libgit2/git2go.v26"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Identity of a person as it appears in commit signatures.
+type Identity struct {
+	Name  string `yaml:"name"`
+	Email string `yaml:"email"`
+}
+
+// Describe how an identity is rewritten. An empty Old name matches any name
+// associated to Old email, empty New fields keep the original values.
+type IdentityMapping struct {
+	Old Identity `yaml:"old"`
+	New Identity `yaml:"new"`
+}
+
+// Interface implemented by processers rewriting identities, allowing other
+// parts of the rewrite (e.g: trailers) to stay consistent with them.
+type IdentityMapper interface {
+	// Returns the rewritten signature, or the signature itself if unchanged.
+	Map(*git.Signature) *git.Signature
+}
+
+// Identity mappers applied in order, each one on the result of the previous.
+type IdentityMappers []IdentityMapper
+
+// Map a signature through every mapper.
+func (im IdentityMappers) Map(sig *git.Signature) *git.Signature {
+	for _, mapper := range im {
+		sig = mapper.Map(sig)
+	}
+	return sig
+}
+
+// This processor rewrites author and committer identities found in a list of
+// mappings, identities without mapping are left untouched.
+type IdentityProcessor struct {
+	// Mappings to apply, mappings specifying an old name take precedence over
+	// the ones matching the email only.
+	Mappings []IdentityMapping
+	// Signatures to rewrite, both author and committer by default.
+	Target Role
+}
+
+// Preprocess is no-op for IdentityProcessor
+func (ip *IdentityProcessor) Preprocess(_ Commits) error { return nil }
+
+// Find the mapping corresponding to a signature, emails and names are
+// compared case insensitively as git does with mailmap files.
+func (ip *IdentityProcessor) lookup(sig *git.Signature) (found *IdentityMapping) {
+	for i, mapping := range ip.Mappings {
+		if !strings.EqualFold(mapping.Old.Email, sig.Email) {
+			continue
+		}
+		if mapping.Old.Name == "" {
+			if found == nil {
+				found = &ip.Mappings[i]
+			}
+		} else if strings.EqualFold(mapping.Old.Name, sig.Name) {
+			return &ip.Mappings[i]
+		}
+	}
+	return
+}
+
+// Map a signature to its new identity, date is kept.
+func (ip *IdentityProcessor) Map(sig *git.Signature) *git.Signature {
+	mapping := ip.lookup(sig)
+	if mapping == nil {
+		return sig
+	}
+	res := *sig
+	if mapping.New.Name != "" {
+		res.Name = mapping.New.Name
+	}
+	if mapping.New.Email != "" {
+		res.Email = mapping.New.Email
+	}
+	return &res
+}
+
+// Change author and/or committer identities according to mappings.
+func (ip *IdentityProcessor) Process(commit *git.Commit) (a, c *git.Signature, m string, e error) {
+	m = commit.RawMessage()
+	a, c = commit.Author(), commit.Committer()
+	if ip.Target.Author() {
+		a = ip.Map(a)
+	}
+	if ip.Target.Committer() {
+		c = ip.Map(c)
+	}
+
+	return
+}
+
+// Load identity mappings from a file. Format is guessed from the extension:
+// .csv and .yml/.yaml files are read as such, any other file is parsed as a
+// git mailmap.
+func LoadIdentities(path string) ([]IdentityMapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return ParseIdentitiesCSV(f)
+	case ".yml", ".yaml":
+		return ParseIdentitiesYAML(f)
+	default:
+		return ParseMailmap(f)
+	}
+}
+
+// Parse a git mailmap file, see gitmailmap(5). Supported forms are:
+//
+//	Proper Name <commit@email.xx>
+//	<proper@email.xx> <commit@email.xx>
+//	Proper Name <proper@email.xx> <commit@email.xx>
+//	Proper Name <proper@email.xx> Commit Name <commit@email.xx>
+func ParseMailmap(r io.Reader) (mappings []IdentityMapping, err error) {
+	scanner := bufio.NewScanner(r)
+	for nb := 1; scanner.Scan(); nb++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var names, emails []string
+		for rest := line; ; {
+			start, end := strings.Index(rest, "<"), strings.Index(rest, ">")
+			if start < 0 || end < start {
+				break
+			}
+			names = append(names, strings.TrimSpace(rest[:start]))
+			emails = append(emails, strings.TrimSpace(rest[start+1:end]))
+			rest = rest[end+1:]
+		}
+
+		switch len(emails) {
+		case 1:
+			mappings = append(mappings, IdentityMapping{
+				Old: Identity{Email: emails[0]}, New: Identity{Name: names[0]},
+			})
+		case 2:
+			mappings = append(mappings, IdentityMapping{
+				Old: Identity{names[1], emails[1]}, New: Identity{names[0], emails[0]},
+			})
+		default:
+			return nil, fmt.Errorf("mailmap line %d: invalid entry %q", nb, line)
+		}
+	}
+	return mappings, scanner.Err()
+}
+
+// Parse mappings from a CSV file with columns: old name, old email, new name,
+// new email. A header line starting with "old_name" is skipped.
+func ParseIdentitiesCSV(r io.Reader) (mappings []IdentityMapping, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return
+	}
+	for i, record := range records {
+		if i == 0 && record[0] == "old_name" {
+			continue
+		}
+		mappings = append(mappings, IdentityMapping{
+			Old: Identity{record[0], record[1]}, New: Identity{record[2], record[3]},
+		})
+	}
+	return
+}
+
+// Parse mappings from a YAML list, e.g:
+//
+//   - old: {name: Old Name, email: old@email.xx}
+//     new: {name: New Name, email: new@email.xx}
+func ParseIdentitiesYAML(r io.Reader) (mappings []IdentityMapping, err error) {
+	var data []byte
+	if data, err = ioutil.ReadAll(r); err != nil {
+		return
+	}
+	err = yaml.Unmarshal(data, &mappings)
+	return
+}
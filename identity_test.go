@@ -0,0 +1,121 @@
+package historiography
+
+import (
+	git "gopkg.in/libgit2/git2go.v26"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMailmap(t *testing.T) {
+	tests := []struct {
+		mailmap  string
+		expected []IdentityMapping
+		err      string
+	}{
+		{
+			"Proper Name <commit@email.xx>",
+			[]IdentityMapping{{Old: Identity{Email: "commit@email.xx"}, New: Identity{Name: "Proper Name"}}},
+			"",
+		},
+		{
+			"<proper@email.xx> <commit@email.xx>",
+			[]IdentityMapping{{Old: Identity{Email: "commit@email.xx"}, New: Identity{Email: "proper@email.xx"}}},
+			"",
+		},
+		{
+			"Proper Name <proper@email.xx> <commit@email.xx>",
+			[]IdentityMapping{{Old: Identity{Email: "commit@email.xx"}, New: Identity{"Proper Name", "proper@email.xx"}}},
+			"",
+		},
+		{
+			"Proper Name <proper@email.xx> Commit Name <commit@email.xx>",
+			[]IdentityMapping{{Old: Identity{"Commit Name", "commit@email.xx"}, New: Identity{"Proper Name", "proper@email.xx"}}},
+			"",
+		},
+		{
+			"# comment\n\n  \nA <a@x> # trailing comment\n<b@x> <c@x>\n",
+			[]IdentityMapping{
+				{Old: Identity{Email: "a@x"}, New: Identity{Name: "A"}},
+				{Old: Identity{Email: "c@x"}, New: Identity{Email: "b@x"}},
+			},
+			"",
+		},
+		{"", nil, ""},
+		{"A <a@x>\nProper Name\n", nil, "mailmap line 2: invalid entry"},
+		{"A <a@x> <b@x> <c@x>", nil, "mailmap line 1: invalid entry"},
+	}
+
+	for _, test := range tests {
+		mappings, err := ParseMailmap(strings.NewReader(test.mailmap))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expected error %q, got %v", test.mailmap, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.mailmap, err)
+			continue
+		}
+		if !reflect.DeepEqual(mappings, test.expected) {
+			t.Errorf("%q: expected %+v, got %+v", test.mailmap, test.expected, mappings)
+		}
+	}
+}
+
+func TestParseIdentitiesCSV(t *testing.T) {
+	mappings, err := ParseIdentitiesCSV(strings.NewReader(
+		"old_name,old_email,new_name,new_email\nOld, old@x, New, new@x\n,a@x,,b@x\n"))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	expected := []IdentityMapping{
+		{Old: Identity{"Old", "old@x"}, New: Identity{"New", "new@x"}},
+		{Old: Identity{Email: "a@x"}, New: Identity{Email: "b@x"}},
+	}
+	if !reflect.DeepEqual(mappings, expected) {
+		t.Errorf("expected %+v, got %+v", expected, mappings)
+	}
+
+	if _, err = ParseIdentitiesCSV(strings.NewReader("a,b,c\n")); err == nil {
+		t.Errorf("expected error on a line with 3 columns")
+	}
+}
+
+func TestParseIdentitiesYAML(t *testing.T) {
+	mappings, err := ParseIdentitiesYAML(strings.NewReader(
+		"- old: {name: Old, email: old@x}\n  new: {name: New, email: new@x}\n- old: {email: a@x}\n  new: {email: b@x}\n"))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	expected := []IdentityMapping{
+		{Old: Identity{"Old", "old@x"}, New: Identity{"New", "new@x"}},
+		{Old: Identity{Email: "a@x"}, New: Identity{Email: "b@x"}},
+	}
+	if !reflect.DeepEqual(mappings, expected) {
+		t.Errorf("expected %+v, got %+v", expected, mappings)
+	}
+}
+
+func TestIdentityProcessorMap(t *testing.T) {
+	ip := &IdentityProcessor{Mappings: []IdentityMapping{
+		{Old: Identity{Email: "old@x"}, New: Identity{Name: "Any"}},
+		{Old: Identity{"Named", "OLD@x"}, New: Identity{"Proper", "new@x"}},
+	}}
+	tests := []struct {
+		name, email, expectedName, expectedEmail string
+	}{
+		{"Someone", "old@x", "Any", "old@x"},
+		{"named", "old@X", "Proper", "new@x"},
+		{"Other", "other@x", "Other", "other@x"},
+	}
+
+	for _, test := range tests {
+		res := ip.Map(&git.Signature{Name: test.name, Email: test.email})
+		if res.Name != test.expectedName || res.Email != test.expectedEmail {
+			t.Errorf("%s <%s>: expected %s <%s>, got %s <%s>", test.name, test.email,
+				test.expectedName, test.expectedEmail, res.Name, res.Email)
+		}
+	}
+}
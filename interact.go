@@ -5,6 +5,7 @@ import (
 	git "gopkg.in/libgit2/git2go.v26"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
@@ -51,3 +52,42 @@ func Confirm(repo *git.Repository) (ok bool, err error) {
 		}
 	}
 }
+
+// Let the user edit a plan in an editor, as git rebase -i does. The editor is
+// taken from GIT_EDITOR, VISUAL or EDITOR environment variables, vi by default.
+func EditPlan(repo *git.Repository, plan Plan, commits Commits) (Plan, error) {
+	editor := "vi"
+	for _, name := range []string{"GIT_EDITOR", "VISUAL", "EDITOR"} {
+		if value := os.Getenv(name); value != "" {
+			editor = value
+			break
+		}
+	}
+
+	path := filepath.Join(repo.Path(), "historiography-plan")
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+	if err = WritePlan(f, plan); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+
+	// editor can contain arguments, let the shell split them
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("editor failed: %s", err)
+	}
+
+	if f, err = os.Open(path); err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadPlan(repo, f, commits)
+}
@@ -0,0 +1,196 @@
+package historiography
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	git "gopkg.in/libgit2/git2go.v26"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Returned when a rewrite is stopped by Interrupt, its journal is kept so it
+// can be resumed.
+var ErrInterrupted = errors.New("rewrite interrupted")
+
+// Directory, in the git dir, holding files of historiography.
+const stateDir = "historiography"
+
+// Journal of a rewrite, stored in the git dir (.git/historiography/), so an
+// interrupted rewrite can be resumed or rolled back. Progress, i.e: commits
+// replayed so far, is stored apart and appended after each commit.
+type Journal struct {
+	// Command line arguments of the rewrite.
+	Args []string `json:"args"`
+	// Identifier of the rewrite.
+	RunID string `json:"run_id"`
+	// Reference rewritten and its target before the rewrite, HEAD if it was
+	// detached.
+	Head   string `json:"head"`
+	Target string `json:"target"`
+	// Temporary branch of the rewrite.
+	Tmp string `json:"tmp"`
+	// Dates decided by schedulers, per commit, see Scheduler.
+	Schedule map[string]time.Time `json:"schedule,omitempty"`
+	// Uncommitted changes stashed before the rewrite, see Options.AutoStash.
+	Stash string `json:"stash,omitempty"`
+}
+
+// Path of a file of the state directory.
+func statePath(repo *git.Repository, name string) string {
+	return filepath.Join(repo.Path(), stateDir, name)
+}
+
+// Read the journal of the rewrite in progress, nil if there is none.
+func ReadJournal(repo *git.Repository) (*Journal, error) {
+	data, err := ioutil.ReadFile(statePath(repo, "journal"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{}
+	if err = json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("invalid journal: %s", err)
+	}
+	return j, nil
+}
+
+// Write the journal, progress is reset unless resuming.
+func (j *Journal) write(repo *git.Repository, resume bool) (progress *os.File, err error) {
+	if err = j.save(repo); err != nil {
+		return
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	return os.OpenFile(statePath(repo, "progress"), flags, 0644)
+}
+
+// Save the journal, replacing the previous one.
+func (j *Journal) save(repo *git.Repository) error {
+	if err := os.MkdirAll(filepath.Join(repo.Path(), stateDir), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	// written aside then renamed, an interruption leaves the previous journal
+	path := statePath(repo, "journal")
+	if err = ioutil.WriteFile(path+".new", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".new", path)
+}
+
+// Step of the progress: action (pick, move, drop or empty), original commit and
+// commit it is mapped to ("-" if none).
+type progress struct {
+	action   string
+	old, new git.Oid
+	mapped   bool
+}
+
+// Read progress of the rewrite.
+func readProgress(repo *git.Repository) (steps []progress, err error) {
+	f, err := os.Open(statePath(repo, "progress"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue // truncated line, the commit is replayed again
+		}
+		old, err := git.NewOid(fields[1])
+		if err != nil {
+			continue // truncated line
+		}
+		step := progress{action: fields[0], old: *old}
+		if fields[2] != "-" {
+			new, err := git.NewOid(fields[2])
+			if err != nil {
+				continue
+			}
+			step.new, step.mapped = *new, true
+		}
+		steps = append(steps, step)
+	}
+	return steps, scanner.Err()
+}
+
+// Remove journal and progress of the rewrite.
+func removeJournal(repo *git.Repository) error {
+	for _, name := range []string{"journal", "progress"} {
+		if err := os.Remove(statePath(repo, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore the repository as it was before an interrupted rewrite: checkout the
+// rewritten reference, or its target if HEAD was detached, and delete the
+// temporary branch. The journal is kept.
+func (j *Journal) Restore(repo *git.Repository) error {
+	if err := repo.StateCleanup(); err != nil {
+		return err
+	}
+	if j.Head == "HEAD" {
+		target, err := git.NewOid(j.Target)
+		if err != nil {
+			return fmt.Errorf("invalid journal: %s", err)
+		}
+		if err = repo.SetHeadDetached(target); err != nil {
+			return err
+		}
+	} else if err := repo.SetHead(j.Head); err != nil {
+		return err
+	}
+	if err := repo.CheckoutHead(&git.CheckoutOpts{Strategy: git.CheckoutForce}); err != nil {
+		return err
+	}
+
+	ref, err := repo.References.Lookup(j.Tmp)
+	if git.IsErrorCode(err, git.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer ref.Free()
+	return ref.Delete()
+}
+
+// Roll back an interrupted rewrite, see Restore, and remove its journal.
+// Changes stashed by the rewrite and not reapplied yet are reapplied.
+func (j *Journal) Abort(repo *git.Repository) error {
+	if err := j.Restore(repo); err != nil {
+		return err
+	}
+	if err := removeJournal(repo); err != nil {
+		return err
+	}
+	if j.Stash == "" {
+		return nil
+	}
+	id, err := git.NewOid(j.Stash)
+	if err != nil {
+		return fmt.Errorf("invalid journal: %s", err)
+	}
+	_, err = popStash(repo, id)
+	return err
+}
@@ -0,0 +1,188 @@
+package historiography
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestReadProgress(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.Free()
+	if err := os.MkdirAll(filepath.Join(tr.repo.Path(), stateDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := strings.Repeat("1", 40)
+	new := strings.Repeat("2", 40)
+
+	tests := []struct {
+		progress string
+		expected []string
+	}{
+		{"", nil},
+		{
+			fmt.Sprintf("pick %s %s\ndrop %s -\nmove %s %s\nempty %s %s\n", old, new, old, old, new, old, new),
+			[]string{"pick 1 2", "drop 1 -", "move 1 2", "empty 1 2"},
+		},
+		// lines truncated by an interruption are ignored
+		{fmt.Sprintf("pick %s %s\npick %s %s", old, new, old, new[:12]), []string{"pick 1 2"}},
+		{fmt.Sprintf("pick %s %s\npick %s", old, new, old[:20]), []string{"pick 1 2"}},
+		{fmt.Sprintf("\npick %s\npick zz%s %s\n", old, old[2:], new), nil},
+	}
+
+	for _, test := range tests {
+		if err := ioutil.WriteFile(statePath(tr.repo, "progress"), []byte(test.progress), 0644); err != nil {
+			t.Fatal(err)
+		}
+		steps, err := readProgress(tr.repo)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.progress, err)
+			continue
+		}
+		var res []string
+		for _, step := range steps {
+			mapped := "-"
+			if step.mapped {
+				mapped = step.new.String()[:1]
+			}
+			res = append(res, fmt.Sprintf("%s %s %s", step.action, step.old.String()[:1], mapped))
+		}
+		if !reflect.DeepEqual(res, test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.progress, test.expected, res)
+		}
+	}
+
+	// no progress at all is no progress
+	if err := removeJournal(tr.repo); err != nil {
+		t.Fatal(err)
+	}
+	if steps, err := readProgress(tr.repo); steps != nil || err != nil {
+		t.Errorf("expected no progress, got %v (%v)", steps, err)
+	}
+}
+
+func TestReadJournal(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.Free()
+
+	if journal, err := ReadJournal(tr.repo); journal != nil || err != nil {
+		t.Fatalf("expected no journal, got %v (%v)", journal, err)
+	}
+
+	expected := &Journal{[]string{"histoctl", "--squash", "day"}, "run", "refs/heads/master",
+		strings.Repeat("1", 40), "refs/heads/tmp", nil, strings.Repeat("2", 40)}
+	if err := expected.save(tr.repo); err != nil {
+		t.Fatal(err)
+	}
+	journal, err := ReadJournal(tr.repo)
+	if err != nil || !reflect.DeepEqual(journal, expected) {
+		t.Errorf("expected %+v, got %+v (%v)", expected, journal, err)
+	}
+
+	if err = ioutil.WriteFile(statePath(tr.repo, "journal"), []byte(`{"args": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadJournal(tr.repo); err == nil || !strings.Contains(err.Error(), "invalid journal") {
+		t.Errorf("expected invalid journal, got %v", err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.Free()
+	tr.commit(testDate, "base", "a", "1")
+	for i := 1; i <= 4; i++ {
+		tr.commit(testDate.Add(time.Duration(i)*time.Minute), fmt.Sprint(i), "b", fmt.Sprint(i))
+	}
+	p := &MessageProcessor{Prefix: template.Must(template.New("prefix").Parse("x: "))}
+	opts := &Options{Commits: -1, Range: "master~4", Args: []string{"histoctl", "--message-prefix", "x: "}}
+
+	// first run, interrupted after two commits
+	h, err := NewHistoriography(tr.repo, p, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := h.Plan(Flatten(h.Commits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Replay(plan[:2]); err != nil {
+		t.Fatal(err)
+	}
+	replayed := []string{h.tmp.Target().String()}
+	h.Interrupt()
+	if err = h.Override(); err != ErrInterrupted {
+		t.Fatalf("expected override to be interrupted, got %v", err)
+	}
+	h.Free()
+	expectStrings(t, "interrupted", []string{"base", "1", "2", "3", "4"}, tr.subjects("master"))
+
+	// the line of the third commit was being written
+	f, err := os.OpenFile(statePath(tr.repo, "progress"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(f, "pick %s", tr.git("rev-parse", "master~1")[:20])
+	f.Close()
+
+	journal, err := ReadJournal(tr.repo)
+	if err != nil || journal == nil {
+		t.Fatalf("expected a journal, got %v (%v)", journal, err)
+	}
+	expectStrings(t, "args", opts.Args, journal.Args)
+
+	// resumed run, commits already replayed are kept
+	resumed := *opts
+	resumed.Resume = true
+	tr.rewrite(p, &resumed, func(h *Historiography) error {
+		// the lock names the interrupted run
+		if holder, err := readLock(statePath(tr.repo, "lock")); err != nil || holder.run != journal.RunID {
+			t.Errorf("expected lock held by run %s, got %+v (%v)", journal.RunID, holder, err)
+		}
+		return h.Process(Flatten(h.Commits))
+	})
+	expectStrings(t, "resumed", []string{"base", "x: 1", "x: 2", "x: 3", "x: 4"}, tr.subjects("master"))
+	expectStrings(t, "replayed", replayed, []string{tr.git("rev-parse", "master~2")})
+
+	if journal, err = ReadJournal(tr.repo); journal != nil || err != nil {
+		t.Errorf("expected journal to be removed, got %v (%v)", journal, err)
+	}
+}
+
+func TestAbortStash(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.Free()
+	tr.commit(testDate, "base", "a", "1")
+	head := tr.commit(testDate.Add(1), "one", "a", "2")
+
+	// a run killed with changes stashed
+	if err := ioutil.WriteFile(filepath.Join(tr.dir, "a"), []byte("3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr.git("stash")
+	journal := &Journal{nil, "run", "refs/heads/master", head, "refs/heads/tmp", nil,
+		tr.git("rev-parse", "refs/stash")}
+	if err := journal.save(tr.repo); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := journal.Abort(tr.repo); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(tr.dir, "a"))
+	if err != nil || string(content) != "3" {
+		t.Errorf("expected stashed changes to be reapplied, got %q (%v)", content, err)
+	}
+	expectStrings(t, "stashes", []string{""}, []string{tr.git("stash", "list")})
+
+	// changes already reapplied by the run itself
+	if err = journal.Abort(tr.repo); err != nil {
+		t.Errorf("expected abort without stash to succeed, got %s", err)
+	}
+}
@@ -0,0 +1,172 @@
+package historiography
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	git "gopkg.in/libgit2/git2go.v26"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Attempts to read the holder of a lock being taken, see lockRepository.
+const lockAttempts = 10
+const lockDelay = 50 * time.Millisecond
+
+var errEmptyLock = errors.New("empty lock file")
+
+// Exclusive lock of a repository, held during a rewrite so two rewrites can
+// not interleave their temporary branches and overrides. The lock file, in the
+// state directory, is locked as long as the rewrite runs (see lockFile) and
+// holds the pid, host and run id of its holder.
+type repoLock struct {
+	path string
+	run  string
+	file *os.File
+}
+
+// Holder of a lock, as written in the lock file.
+type lockHolder struct {
+	pid   int
+	host  string
+	run   string
+	since time.Time
+}
+
+// Take the lock of the repository. The kernel releases the lock of a process
+// which is not running anymore, its lock file is then stale and taken over.
+func lockRepository(repo *git.Repository, run string) (*repoLock, error) {
+	if err := os.MkdirAll(filepath.Join(repo.Path(), stateDir), 0755); err != nil {
+		return nil, err
+	}
+	l := &repoLock{path: statePath(repo, "lock"), run: run}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(lockDelay)
+		}
+		ok, err := l.acquire()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if holder, err := readLock(l.path); err == nil {
+				glog.Warningf("taking over stale lock of process %d (run %s)", holder.pid, holder.run)
+			}
+			if err = l.write(hostname()); err != nil {
+				l.file.Close()
+				return nil, err
+			}
+			return l, nil
+		}
+
+		// the holder may not have written itself yet, or released the lock
+		holder, err := readLock(l.path)
+		if err != nil && attempt < lockAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("repository is locked by histoctl (pid %d on %s, run %s) since %s",
+			holder.pid, holder.host, holder.run, holder.since.Format(time.RFC3339))
+	}
+}
+
+// Name of the host written in lock files.
+func hostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
+
+// Record another run as the holder of the lock, e.g: the interrupted run a
+// rewrite resumes.
+func (l *repoLock) hold(run string) error {
+	l.run = run
+	return l.write(hostname())
+}
+
+// Open and lock the lock file, false is returned if another process holds it.
+func (l *repoLock) acquire() (bool, error) {
+	f, err := openLock(l.path)
+	if err != nil {
+		return false, err
+	}
+	if ok, err := lockFile(f); !ok || err != nil {
+		f.Close()
+		return false, err
+	}
+
+	// the previous holder removes the file when releasing it, the one locked
+	// may not be the lock file anymore
+	opened, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return false, err
+	}
+	if current, err := os.Stat(l.path); err != nil || !os.SameFile(opened, current) {
+		f.Close()
+		return false, nil
+	}
+	l.file = f
+	return true, nil
+}
+
+// Write the holder of the lock in the locked file.
+func (l *repoLock) write(host string) error {
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.file.WriteAt([]byte(fmt.Sprintf("%d %s %s %d\n",
+		os.Getpid(), host, l.run, time.Now().Unix())), 0)
+	return err
+}
+
+// Read the holder of a lock file.
+func readLock(path string) (holder lockHolder, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return holder, errEmptyLock
+	}
+	var since int64
+	if _, err = fmt.Sscanf(string(data), "%d %s %s %d",
+		&holder.pid, &holder.host, &holder.run, &since); err != nil {
+		return holder, fmt.Errorf("invalid lock file %s: %s", path, err)
+	}
+	holder.since = time.Unix(since, 0)
+	return
+}
+
+// Release the lock, releasing twice is harmless. The lock file is only removed
+// if it still names the run holding it.
+func (l *repoLock) release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	defer func() {
+		l.file.Close() // unlocks the file
+		l.file = nil
+	}()
+
+	holder, err := readLock(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if holder.run != l.run {
+		return fmt.Errorf("lock %s is held by run %s instead of %s, left in place",
+			l.path, holder.run, l.run)
+	}
+	return os.Remove(l.path)
+}
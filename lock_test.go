@@ -0,0 +1,144 @@
+package historiography
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "historiography")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+
+	tests := []struct {
+		content  string
+		expected lockHolder
+		err      string
+	}{
+		{"42 host run 1136214245\n", lockHolder{42, "host", "run", time.Unix(1136214245, 0)}, ""},
+		{"", lockHolder{}, errEmptyLock.Error()},
+		{" \n", lockHolder{}, errEmptyLock.Error()},
+		{"42 host", lockHolder{}, "invalid lock file"},
+		{"pid host run 1136214245\n", lockHolder{}, "invalid lock file"},
+	}
+
+	for _, test := range tests {
+		if err = ioutil.WriteFile(path, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		holder, err := readLock(path)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expected error %q, got %v", test.content, test.err, err)
+			}
+			continue
+		}
+		if err != nil || holder != test.expected {
+			t.Errorf("%q: expected %+v, got %+v (%v)", test.content, test.expected, holder, err)
+		}
+	}
+
+	if _, err = readLock(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("expected missing lock file, got %v", err)
+	}
+}
+
+func TestLockAcquireRelease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "historiography")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+
+	first := &repoLock{path: path, run: "first"}
+	if ok, err := first.acquire(); !ok || err != nil {
+		t.Fatalf("expected lock to be acquired, got %t (%v)", ok, err)
+	}
+	if err = first.write("host"); err != nil {
+		t.Fatal(err)
+	}
+	if holder, err := readLock(path); err != nil || holder.run != "first" || holder.pid != os.Getpid() {
+		t.Errorf("expected lock held by first, got %+v (%v)", holder, err)
+	}
+
+	// held locks can not be acquired, even by the same process
+	second := &repoLock{path: path, run: "second"}
+	if ok, err := second.acquire(); ok || err != nil {
+		t.Errorf("expected lock to be busy, got %t (%v)", ok, err)
+	}
+
+	if err = first.release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected lock file to be removed, got %v", err)
+	}
+	if err = first.release(); err != nil {
+		t.Errorf("releasing twice: %s", err)
+	}
+
+	if ok, err := second.acquire(); !ok || err != nil {
+		t.Fatalf("expected released lock to be acquired, got %t (%v)", ok, err)
+	}
+	second.release()
+}
+
+func TestLockReleaseOtherRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "historiography")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+
+	l := &repoLock{path: path, run: "mine"}
+	if ok, err := l.acquire(); !ok || err != nil {
+		t.Fatalf("expected lock to be acquired, got %t (%v)", ok, err)
+	}
+	if err = ioutil.WriteFile(path, []byte("1 host other 1136214245\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lock file of another run is left in place
+	if err = l.release(); err == nil || !strings.Contains(err.Error(), "held by run other") {
+		t.Errorf("expected release to be refused, got %v", err)
+	}
+	if holder, err := readLock(path); err != nil || holder.run != "other" {
+		t.Errorf("expected lock file of other run, got %+v (%v)", holder, err)
+	}
+	if l.file != nil {
+		t.Errorf("expected lock file to be closed")
+	}
+}
+
+func TestLockRepository(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.Free()
+
+	first, err := lockRepository(tr.repo, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = lockRepository(tr.repo, "second"); err == nil ||
+		!strings.Contains(err.Error(), "repository is locked by histoctl") ||
+		!strings.Contains(err.Error(), "run first") {
+		t.Errorf("expected repository to be locked by first, got %v", err)
+	}
+
+	if err = first.release(); err != nil {
+		t.Fatal(err)
+	}
+	second, err := lockRepository(tr.repo, "second")
+	if err != nil {
+		t.Fatalf("expected released repository to be locked, got %s", err)
+	}
+	second.release()
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package historiography
+
+import (
+	"os"
+	"syscall"
+)
+
+// Open the lock file, created if needed.
+func openLock(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+}
+
+// Lock the file with flock(2), false is returned if another process holds it.
+// The kernel releases the lock once the file is closed or the process exits.
+func lockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
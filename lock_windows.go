@@ -0,0 +1,51 @@
+//go:build windows
+// +build windows
+
+package historiography
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errLockViolation        = syscall.Errno(33) // ERROR_LOCK_VIOLATION
+)
+
+// Open the lock file, created if needed. It is shared for deletion so that it
+// can be removed while locked, like on Unix, see repoLock.release.
+func openLock(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE, nil,
+		syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
+
+// Lock the file with LockFileEx, false is returned if another process holds it.
+// Locks are mandatory on Windows: a byte far beyond the content is locked so
+// that the holder can still be read. The lock is released once the file is
+// closed or the process exits.
+func lockFile(f *os.File) (bool, error) {
+	overlapped := syscall.Overlapped{OffsetHigh: 0x7fffffff}
+	ok, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately,
+		0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if ok != 0 {
+		return true, nil
+	}
+	if err == errLockViolation {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,185 @@
+package historiography
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	git "gopkg.in/libgit2/git2go.v26"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Processers implementing MessageFilter receive, when composed, the message
+// produced by previous processers instead of the original commit message, so
+// several message changes can be chained. Their Process is not called then,
+// they must leave author and committer untouched.
+type MessageFilter interface {
+	// Returns message m changed for commit.
+	FilterMessage(commit *git.Commit, m string) (string, error)
+}
+
+// Replace every match of Pattern by Replace, which can reference submatches as
+// described by regexp.Expand.
+type Replacement struct {
+	Pattern *regexp.Regexp
+	Replace string
+}
+
+// Default replacement text when none is specified in a replacements file.
+const removed = "***REMOVED***"
+
+// Load replacements from a file, see ParseReplacements for the format.
+func LoadReplacements(path string) ([]Replacement, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseReplacements(f)
+}
+
+// Parse replacements, one per line, in the format used by git-filter-repo:
+//
+//	literal text==>replacement
+//	regex:pattern==>replacement
+//	literal text
+//
+// Without replacement, matches are replaced by ***REMOVED***. Empty lines and
+// lines starting with # are ignored.
+func ParseReplacements(r io.Reader) (replacements []Replacement, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		replace := removed
+		if i := strings.LastIndex(line, "==>"); i >= 0 {
+			line, replace = line[:i], line[i+3:]
+		}
+
+		var pattern *regexp.Regexp
+		if strings.HasPrefix(line, "regex:") {
+			if pattern, err = regexp.Compile(line[len("regex:"):]); err != nil {
+				return
+			}
+		} else {
+			pattern = regexp.MustCompile(regexp.QuoteMeta(strings.TrimPrefix(line, "literal:")))
+			replace = strings.Replace(replace, "$", "$$", -1) // no expansion on literals
+		}
+		replacements = append(replacements, Replacement{pattern, replace})
+	}
+	return replacements, scanner.Err()
+}
+
+// Data available to message templates, e.g: "[{{.ShortID}}] ".
+type MessageData struct {
+	ID, ShortID       string
+	Author, Committer *git.Signature
+	Summary, Message  string
+}
+
+// Processor rewriting commit messages. Changes are applied in order:
+// replacements, prefix and suffix, normalisation.
+type MessageProcessor struct {
+	// Replacements applied on the whole message.
+	Replacements []Replacement
+	// Templates executed with MessageData and prepended/appended to the message.
+	Prefix, Suffix *template.Template
+	// Normalise whitespaces and encoding: line endings are converted to LF,
+	// invalid UTF-8 sequences are replaced, trailing whitespaces and repeated
+	// blank lines are removed, and message ends with a single newline.
+	Normalize bool
+}
+
+// Preprocess is no-op for MessageProcessor
+func (mp *MessageProcessor) Preprocess(_ Commits) error { return nil }
+
+// Change commit message, author and committer are left untouched.
+func (mp *MessageProcessor) Process(commit *git.Commit) (a, c *git.Signature, m string, e error) {
+	a, c = commit.Author(), commit.Committer()
+	m, e = mp.FilterMessage(commit, commit.RawMessage())
+
+	return
+}
+
+// Apply replacements, templates and normalisation on message m.
+func (mp *MessageProcessor) FilterMessage(commit *git.Commit, m string) (string, error) {
+	for _, replacement := range mp.Replacements {
+		m = replacement.Pattern.ReplaceAllString(m, replacement.Replace)
+	}
+
+	if mp.Prefix != nil || mp.Suffix != nil {
+		id := commit.Id().String()
+		data := MessageData{
+			ID: id, ShortID: id[:7], Author: commit.Author(), Committer: commit.Committer(),
+			Summary: commit.Summary(), Message: commit.RawMessage(),
+		}
+		if mp.Prefix != nil {
+			prefix, err := execute(mp.Prefix, &data)
+			if err != nil {
+				return "", err
+			}
+			m = prefix + m
+		}
+		if mp.Suffix != nil {
+			suffix, err := execute(mp.Suffix, &data)
+			if err != nil {
+				return "", err
+			}
+			m = strings.TrimRight(m, "\n") + suffix + "\n"
+		}
+	}
+
+	if mp.Normalize {
+		m = NormalizeMessage(m)
+	}
+	return m, nil
+}
+
+// Execute a template and returns its output.
+func execute(tpl *template.Template, data *MessageData) (string, error) {
+	var buf bytes.Buffer
+	err := tpl.Execute(&buf, data)
+	return buf.String(), err
+}
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// Normalise whitespaces and encoding of a message the way git stripspace
+// does, invalid UTF-8 sequences are replaced by the replacement character.
+func NormalizeMessage(m string) string {
+	m = strings.ToValidUTF8(m, "�")
+	m = strings.Replace(m, "\r\n", "\n", -1)
+
+	lines := strings.Split(m, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	m = strings.Trim(strings.Join(lines, "\n"), "\n")
+	if m == "" {
+		return m
+	}
+	return blankLines.ReplaceAllString(m, "\n\n") + "\n"
+}
+
+// Combine messages of squashed commits: the subject tells how many commits
+// were squashed and the body lists their subjects, e.g:
+//
+//	Squash 3 commits
+//
+//	* first commit subject
+//	* second commit subject
+//	* third commit subject
+func SquashMessage(messages []string) string {
+	lines := []string{fmt.Sprintf("Squash %d commits", len(messages)), ""}
+	for _, m := range messages {
+		subject := strings.TrimSpace(strings.SplitN(strings.TrimSpace(m), "\n", 2)[0])
+		lines = append(lines, "* "+subject)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
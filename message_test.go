@@ -0,0 +1,67 @@
+package historiography
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReplacements(t *testing.T) {
+	tests := []struct {
+		replacements, input, expected, err string
+	}{
+		{"password", "my password is here", "my ***REMOVED*** is here", ""},
+		{"secret==>xxx", "a secret, another secret", "a xxx, another xxx", ""},
+		{"literal:a.b==>c", "a.b axb", "c axb", ""},
+		{"1+1==>$1 two", "1+1=2", "$1 two=2", ""},
+		{`regex:(\w+)@corp\.com==>$1@example.com`, "bob@corp.com", "bob@example.com", ""},
+		{`regex:[0-9]{4}`, "pin 1234", "pin ***REMOVED***", ""},
+		{"a==>b==>c", "a==>b", "c", ""},
+		{"# comment\n\nfoo==>bar\n  \nbar==>baz", "foo", "baz", ""},
+		{"regex:(", "", "", "missing closing )"},
+	}
+
+	for _, test := range tests {
+		replacements, err := ParseReplacements(strings.NewReader(test.replacements))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expected error %q, got %v", test.replacements, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.replacements, err)
+			continue
+		}
+		mp := &MessageProcessor{Replacements: replacements}
+		if res, _ := mp.FilterMessage(nil, test.input); res != test.expected {
+			t.Errorf("%q on %q: expected %q, got %q", test.replacements, test.input, test.expected, res)
+		}
+	}
+}
+
+func TestNormalizeMessage(t *testing.T) {
+	tests := []struct {
+		message, expected string
+	}{
+		{"subject", "subject\n"},
+		{"subject\r\n\r\nbody\r\n", "subject\n\nbody\n"},
+		{"subject  \t\n\nbody \n", "subject\n\nbody\n"},
+		{"\n\nsubject\n\n\n\nbody\n\n\n", "subject\n\nbody\n"},
+		{"sub\xffject", "sub�ject\n"},
+		{"", ""},
+		{" \n\t\n", ""},
+	}
+
+	for _, test := range tests {
+		if res := NormalizeMessage(test.message); res != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.message, test.expected, res)
+		}
+	}
+}
+
+func TestSquashMessage(t *testing.T) {
+	expected := "Squash 2 commits\n\n* first\n* second\n"
+	if res := SquashMessage([]string{"\nfirst \n\nbody\n", "second"}); res != expected {
+		t.Errorf("expected %q, got %q", expected, res)
+	}
+}
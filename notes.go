@@ -0,0 +1,130 @@
+package historiography
+
+import (
+	git "gopkg.in/libgit2/git2go.v26"
+	"os"
+	"strings"
+)
+
+// Returns notes references to carry over rewritten commits, the way git does:
+// GIT_NOTES_REWRITE_REF environment variable (colon separated) if set,
+// notes.rewriteRef configuration values otherwise. References can be globs,
+// e.g: refs/notes/*.
+func NotesRewriteRefs(repo *git.Repository) (refs []string, err error) {
+	if env := os.Getenv("GIT_NOTES_REWRITE_REF"); env != "" {
+		return strings.Split(env, ":"), nil
+	}
+
+	config, err := repo.Config()
+	if err != nil {
+		return
+	}
+	defer config.Free()
+
+	iterator, err := config.NewMultivarIterator("notes.rewriteRef", "")
+	if git.IsErrorCode(err, git.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	defer iterator.Free()
+
+	for {
+		entry, err := iterator.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			return refs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, entry.Value)
+	}
+}
+
+// Expand globs of notes references to existing references.
+func (h *Historiography) notesRefs(patterns []string) (refs []string, err error) {
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			refs = append(refs, pattern)
+			continue
+		}
+		var iterator *git.ReferenceIterator
+		if iterator, err = h.repo.NewReferenceIteratorGlob(pattern); err != nil {
+			return
+		}
+		names := iterator.Names()
+		for {
+			name, err := names.Next()
+			if git.IsErrorCode(err, git.ErrIterOver) {
+				break
+			}
+			if err != nil {
+				iterator.Free()
+				return nil, err
+			}
+			refs = append(refs, name)
+		}
+		iterator.Free()
+	}
+	return
+}
+
+// Copy notes of rewritten commits to their rewritten counterparts for each
+// notes reference (e.g: refs/notes/ci), as git does with notes.rewriteRef.
+// Notes of commits squashed together are concatenated, notes of dropped
+// commits are not copied. Note text is passed through filter if not nil.
+// Returns the number of notes written.
+func (h *Historiography) CopyNotes(patterns []string, filter MessageFilter) (count int, err error) {
+	refs, err := h.notesRefs(patterns)
+	if err != nil {
+		return
+	}
+
+	var sig *git.Signature
+	for _, ref := range refs {
+		// gather notes per rewritten commit, in order
+		ids, notes := []git.Oid{}, map[git.Oid][]string{}
+		for _, commit := range Flatten(h.Commits) {
+			old, new := *commit.Id(), h.resolve(*commit.Id())
+			if new == old || h.skipped[old] || h.emptied[old] {
+				continue
+			}
+
+			note, err := h.repo.Notes.Read(ref, &old)
+			if git.IsErrorCode(err, git.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return count, err
+			}
+			text := note.Message()
+			note.Free()
+
+			if filter != nil {
+				if text, err = filter.FilterMessage(commit, text); err != nil {
+					return count, err
+				}
+			}
+			if _, ok := notes[new]; !ok {
+				ids = append(ids, new)
+			}
+			notes[new] = append(notes[new], text)
+		}
+
+		// the identity of the user is only needed to write notes
+		if len(ids) > 0 && sig == nil {
+			if sig, err = h.repo.DefaultSignature(); err != nil {
+				return
+			}
+		}
+		for _, id := range ids {
+			text := strings.Join(notes[id], "\n")
+			if _, err = h.repo.Notes.Create(ref, sig, sig, &id, text, true); err != nil {
+				return
+			}
+			count++
+		}
+	}
+	return
+}
@@ -0,0 +1,161 @@
+package historiography
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/glog"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	git "gopkg.in/libgit2/git2go.v26"
+	"strings"
+)
+
+// Header of a raw commit object, e.g: "gpgsig" or "encoding".
+type header struct {
+	Key, Value string
+}
+
+// Format a signature as written in commit objects, e.g:
+// "Name <email> 1136214245 -0700".
+func formatSignature(sig *git.Signature) string {
+	_, offset := sig.When.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("%s <%s> %d %c%02d%02d",
+		sig.Name, sig.Email, sig.When.Unix(), sign, offset/3600, offset%3600/60)
+}
+
+// Format a commit object the way git does, headers are written after the
+// committer one, multi-line values are continued on lines starting with a
+// space.
+func formatCommit(tree *git.Oid, parents []*git.Oid, a, c *git.Signature,
+	headers []header, m string) []byte {
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %s\n", tree)
+	for _, parent := range parents {
+		fmt.Fprintf(&buf, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&buf, "author %s\n", formatSignature(a))
+	fmt.Fprintf(&buf, "committer %s\n", formatSignature(c))
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%s %s\n", h.Key, strings.Replace(h.Value, "\n", "\n ", -1))
+	}
+	buf.WriteString("\n")
+	buf.WriteString(m)
+	return buf.Bytes()
+}
+
+// Split a raw commit object in its headers and message.
+func parseCommit(data []byte) (headers []header, m string) {
+	raw := string(data)
+	end := strings.Index(raw, "\n\n")
+	if end < 0 {
+		end = len(raw)
+		m = ""
+	} else {
+		m = raw[end+2:]
+	}
+
+	for _, line := range strings.Split(raw[:end], "\n") {
+		if strings.HasPrefix(line, " ") && len(headers) > 0 {
+			headers[len(headers)-1].Value += "\n" + line[1:]
+			continue
+		}
+		kv := strings.SplitN(line, " ", 2)
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		headers = append(headers, header{kv[0], kv[1]})
+	}
+	return
+}
+
+// Read headers of a commit from the object database.
+func readHeaders(repo *git.Repository, oid *git.Oid) ([]header, error) {
+	odb, err := repo.Odb()
+	if err != nil {
+		return nil, err
+	}
+	defer odb.Free()
+
+	obj, err := odb.Read(oid)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Free()
+
+	headers, _ := parseCommit(obj.Data())
+	return headers, nil
+}
+
+// Labels of ISO-8859-1, lowercased without dashes and underscores.
+var latin1 = map[string]bool{"iso88591": true, "iso885911987": true, "latin1": true, "l1": true}
+
+// Returns the encoding of a commit message given by its encoding header, nil
+// for UTF-8. Unknown encodings are reported and messages handled as UTF-8.
+func messageEncoding(repo *git.Repository, commit *git.Commit) (encoding.Encoding, error) {
+	headers, err := readHeaders(repo, commit.Id())
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range headers {
+		if h.Key != "encoding" {
+			continue
+		}
+		name := strings.ToLower(h.Value)
+		if name == "utf-8" || name == "utf8" {
+			return nil, nil
+		}
+		// htmlindex follows browsers, which read ISO-8859-1 as windows-1252
+		if latin1[strings.NewReplacer("-", "", "_", "").Replace(name)] {
+			return charmap.ISO8859_1, nil
+		}
+		enc, err := htmlindex.Get(h.Value)
+		if err != nil {
+			glog.Warningf("commit %s: unknown encoding %q, message handled as UTF-8",
+				commit.Id().String()[:10], h.Value)
+			return nil, nil
+		}
+		return enc, nil
+	}
+	return nil, nil
+}
+
+// Decode message m of a commit to UTF-8 according to its encoding header.
+func decodeMessage(repo *git.Repository, commit *git.Commit, m string) (string, error) {
+	enc, err := messageEncoding(repo, commit)
+	if err != nil || enc == nil {
+		return m, err
+	}
+	return enc.NewDecoder().String(m)
+}
+
+// Filter message m of a commit in encoding enc as UTF-8, then encode it back.
+// A nil encoding is UTF-8.
+func filterEncoded(filter MessageFilter, commit *git.Commit, m string, enc encoding.Encoding) (string, error) {
+	if enc == nil {
+		return filter.FilterMessage(commit, m)
+	}
+	m, err := enc.NewDecoder().String(m)
+	if err != nil {
+		return "", err
+	}
+	if m, err = filter.FilterMessage(commit, m); err != nil {
+		return "", err
+	}
+	return encoding.ReplaceUnsupported(enc.NewEncoder()).String(m)
+}
+
+// Write a raw commit object in the object database.
+func writeCommit(repo *git.Repository, data []byte) (*git.Oid, error) {
+	odb, err := repo.Odb()
+	if err != nil {
+		return nil, err
+	}
+	defer odb.Free()
+	return odb.Write(data, git.ObjectCommit)
+}
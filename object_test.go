@@ -0,0 +1,131 @@
+package historiography
+
+import (
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	git "gopkg.in/libgit2/git2go.v26"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatSignature(t *testing.T) {
+	tests := []struct {
+		offset   int
+		expected string
+	}{
+		{0, "A <a@x> 1136214245 +0000"},
+		{-7 * 3600, "A <a@x> 1136214245 -0700"},
+		{5*3600 + 30*60, "A <a@x> 1136214245 +0530"},
+		{-(3*3600 + 30*60), "A <a@x> 1136214245 -0330"},
+	}
+
+	for _, test := range tests {
+		when := time.Unix(1136214245, 0).In(time.FixedZone("", test.offset))
+		if res := formatSignature(&git.Signature{Name: "A", Email: "a@x", When: when}); res != test.expected {
+			t.Errorf("offset %d: expected %q, got %q", test.offset, test.expected, res)
+		}
+	}
+}
+
+func TestFormatCommit(t *testing.T) {
+	tree, _ := git.NewOid("4b825dc642cb6eb9a060e54bf8d69288fbee4904")
+	p1, _ := git.NewOid("1111111111111111111111111111111111111111")
+	p2, _ := git.NewOid("2222222222222222222222222222222222222222")
+	when := time.Unix(1136214245, 0).In(time.FixedZone("", -7*3600))
+	a := &git.Signature{Name: "Author", Email: "a@x", When: when}
+	c := &git.Signature{Name: "Committer", Email: "c@x", When: when}
+	standard := []header{
+		{"tree", tree.String()},
+		{"author", "Author <a@x> 1136214245 -0700"},
+		{"committer", "Committer <c@x> 1136214245 -0700"},
+	}
+
+	tests := []struct {
+		parents []*git.Oid
+		headers []header
+		message string
+		raw     string
+	}{
+		{
+			nil, nil, "subject\n",
+			"tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+				"author Author <a@x> 1136214245 -0700\n" +
+				"committer Committer <c@x> 1136214245 -0700\n" +
+				"\nsubject\n",
+		},
+		{
+			[]*git.Oid{p1, p2}, []header{{"encoding", "ISO-8859-1"}}, "merge\n\nbody\n\n",
+			"tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+				"parent 1111111111111111111111111111111111111111\n" +
+				"parent 2222222222222222222222222222222222222222\n" +
+				"author Author <a@x> 1136214245 -0700\n" +
+				"committer Committer <c@x> 1136214245 -0700\n" +
+				"encoding ISO-8859-1\n" +
+				"\nmerge\n\nbody\n\n",
+		},
+		{
+			[]*git.Oid{p1}, []header{{"gpgsig", "-----BEGIN SSH SIGNATURE-----\nU1NIU0lH\n\n-----END SSH SIGNATURE-----"}},
+			"",
+			"tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+				"parent 1111111111111111111111111111111111111111\n" +
+				"author Author <a@x> 1136214245 -0700\n" +
+				"committer Committer <c@x> 1136214245 -0700\n" +
+				"gpgsig -----BEGIN SSH SIGNATURE-----\n U1NIU0lH\n \n -----END SSH SIGNATURE-----\n" +
+				"\n",
+		},
+	}
+
+	for _, test := range tests {
+		raw := string(formatCommit(tree, test.parents, a, c, test.headers, test.message))
+		if raw != test.raw {
+			t.Errorf("expected %q, got %q", test.raw, raw)
+		}
+
+		// parsing gives back headers, standard ones included, and the message
+		headers, m := parseCommit([]byte(raw))
+		expected := append([]header{}, standard[0])
+		for _, parent := range test.parents {
+			expected = append(expected, header{"parent", parent.String()})
+		}
+		expected = append(append(expected, standard[1:]...), test.headers...)
+		if !reflect.DeepEqual(headers, expected) || m != test.message {
+			t.Errorf("%q: expected %v %q, got %v %q", raw, expected, test.message, headers, m)
+		}
+	}
+}
+
+func TestParseCommitWithoutMessage(t *testing.T) {
+	headers, m := parseCommit([]byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\nmergetag"))
+	expected := []header{{"tree", "4b825dc642cb6eb9a060e54bf8d69288fbee4904"}, {"mergetag", ""}}
+	if !reflect.DeepEqual(headers, expected) || m != "" {
+		t.Errorf("expected %v %q, got %v %q", expected, "", headers, m)
+	}
+}
+
+func TestFilterEncoded(t *testing.T) {
+	replacements, err := ParseReplacements(strings.NewReader("café==>thé\nEUR==>€"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := &MessageProcessor{Replacements: replacements}
+
+	tests := []struct {
+		message  string
+		enc      encoding.Encoding
+		expected string
+	}{
+		{"un café\n", nil, "un thé\n"},
+		{"un caf\xe9\n", charmap.ISO8859_1, "un th\xe9\n"},
+		// characters missing from the encoding are replaced
+		{"5 EUR\n", charmap.ISO8859_1, "5 \x1a\n"},
+	}
+
+	for _, test := range tests {
+		res, err := filterEncoded(filter, nil, test.message, test.enc)
+		if err != nil || res != test.expected {
+			t.Errorf("%q: expected %q, got %q (%v)", test.message, test.expected, res, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package historiography
+
+import (
+	"bufio"
+	"fmt"
+	git "gopkg.in/libgit2/git2go.v26"
+	"io"
+	"strings"
+)
+
+// Action performed on a commit of a plan.
+type Action int
+
+const (
+	// Replay the commit, default.
+	Pick Action = iota
+	// Remove the commit, its changes are removed from following commits.
+	Drop
+)
+
+// Name of the action as written in plans.
+func (a Action) String() string {
+	if a == Drop {
+		return "drop"
+	}
+	return "pick"
+}
+
+// Commit of a plan and what to do with it.
+type Step struct {
+	Action Action
+	Commit *git.Commit
+	// Original ids of the parents to use instead of the commit ones, nil keeps
+	// them. Parents are mapped to their rewritten counterparts on replay.
+	Parents []git.Oid
+}
+
+// Ordered list of steps replayed on the temporary branch, a commit appearing
+// before its parent is replayed on top of the previous step, like
+// git rebase -i does.
+type Plan []Step
+
+// Build the default plan: every commit is picked, in the given order.
+func NewPlan(commits Commits) (plan Plan) {
+	for _, commit := range commits {
+		plan = append(plan, Step{Pick, commit, nil})
+	}
+	return
+}
+
+// Processers implementing Planner can drop commits, reorder them, or change
+// their parents before they are replayed.
+type Planner interface {
+	// Returns plan changed.
+	Plan(plan Plan) (Plan, error)
+}
+
+// Processor dropping commits matching a predicate.
+type DropProcessor struct {
+	Condition Predicate
+}
+
+// Preprocess is no-op for DropProcessor
+func (dp *DropProcessor) Preprocess(_ Commits) error { return nil }
+
+// Process is no-op for DropProcessor, commits are dropped when planning.
+func (dp *DropProcessor) Process(commit *git.Commit) (a, c *git.Signature, m string, e error) {
+	return commit.Author(), commit.Committer(), commit.RawMessage(), nil
+}
+
+// Mark commits matching the condition as dropped.
+func (dp *DropProcessor) Plan(plan Plan) (Plan, error) {
+	for i, step := range plan {
+		ok, err := dp.Condition.Match(step.Commit)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			plan[i].Action = Drop
+		}
+	}
+	return plan, nil
+}
+
+// Error returned when a commit can not be replayed on its new parent.
+type ConflictError struct {
+	Commit git.Oid
+	// Paths in conflict.
+	Paths []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict while replaying commit %s: %s",
+		e.Commit.String()[:10], strings.Join(e.Paths, ", "))
+}
+
+// Write a plan in a format close to the git rebase -i one, e.g:
+//
+//	pick 1a2b3c4d5e first commit subject
+//	drop 5e4d3c2b1a second commit subject
+//	pick 0a1b2c3d4e parents=1a2b3c4d5e,9f8e7d6c5b merge subject
+func WritePlan(w io.Writer, plan Plan) (err error) {
+	for _, step := range plan {
+		line := fmt.Sprintf("%s %s", step.Action, step.Commit.Id().String()[:10])
+		if step.Parents != nil {
+			parents := make([]string, len(step.Parents))
+			for i, parent := range step.Parents {
+				parents[i] = parent.String()[:10]
+			}
+			line += " parents=" + strings.Join(parents, ",")
+		}
+		if _, err = fmt.Fprintf(w, "%s %s\n", line, step.Commit.Summary()); err != nil {
+			return
+		}
+	}
+	_, err = fmt.Fprint(w, `
+# Commands:
+# p, pick <commit> [parents=<commit>,...] = replay commit (on other parents)
+# d, drop <commit> = remove commit
+#
+# Lines can be reordered, they are replayed from top to bottom.
+# Removing a line drops the commit.
+`)
+	return
+}
+
+// Read a plan written by WritePlan. Commits must belong to commits, commits
+// missing from the plan are dropped.
+func ReadPlan(repo *git.Repository, r io.Reader, commits Commits) (plan Plan, err error) {
+	byId, seen := map[git.Oid]*git.Commit{}, map[git.Oid]bool{}
+	for _, commit := range commits {
+		byId[*commit.Id()] = commit
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid plan line %q", scanner.Text())
+		}
+
+		var step Step
+		switch fields[0] {
+		case "p", "pick":
+			step.Action = Pick
+		case "d", "drop":
+			step.Action = Drop
+		default:
+			return nil, fmt.Errorf("unknown plan action %q", fields[0])
+		}
+
+		var oid *git.Oid
+		if oid, err = revision(repo, fields[1]); err != nil {
+			return
+		}
+		if step.Commit = byId[*oid]; step.Commit == nil {
+			return nil, fmt.Errorf("commit %s is not part of the rewrite", fields[1])
+		}
+		if seen[*oid] {
+			return nil, fmt.Errorf("commit %s appears twice in plan", fields[1])
+		}
+		seen[*oid] = true
+
+		if len(fields) > 2 && strings.HasPrefix(fields[2], "parents=") {
+			step.Parents = []git.Oid{}
+			for _, spec := range strings.Split(strings.TrimPrefix(fields[2], "parents="), ",") {
+				if oid, err = revision(repo, spec); err != nil {
+					return
+				}
+				step.Parents = append(step.Parents, *oid)
+			}
+		}
+		plan = append(plan, step)
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	// removed lines drop commits, drops are replayed first
+	dropped := Plan{}
+	for _, commit := range commits {
+		if !seen[*commit.Id()] {
+			dropped = append(dropped, Step{Drop, commit, nil})
+		}
+	}
+	return append(dropped, plan...), nil
+}
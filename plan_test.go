@@ -0,0 +1,158 @@
+package historiography
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// History shared by plan tests, returns ids of commits by subject.
+func planHistory(tr *testRepo) map[string]string {
+	ids := map[string]string{}
+	ids["base"] = tr.commit(testDate, "base", "a", "1")
+	ids["one"] = tr.commit(testDate.Add(1), "one", "b", "1")
+	ids["two"] = tr.commit(testDate.Add(2), "two", "b", "2")
+	ids["three"] = tr.commit(testDate.Add(3), "three", "c", "1")
+	ids["four"] = tr.commit(testDate.Add(4), "four", "d", "1")
+	return ids
+}
+
+// Replace {subject} placeholders of a plan by commit ids.
+func planText(plan string, ids map[string]string) string {
+	pairs := []string{}
+	for subject, id := range ids {
+		pairs = append(pairs, "{"+subject+"}", id)
+	}
+	return strings.NewReplacer(pairs...).Replace(plan)
+}
+
+func TestReadPlan(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.Free()
+	ids := planHistory(tr)
+	subjects := map[string]string{}
+	for subject, id := range ids {
+		subjects[id] = subject
+	}
+	commits := Commits{tr.lookup(ids["one"]), tr.lookup(ids["two"]), tr.lookup(ids["three"])}
+
+	tests := []struct {
+		plan, expected, err string
+	}{
+		{"pick {three}\npick {one}", "drop two, pick three, pick one", ""},
+		{"p {one} parents={base}\nd {two} subject\n# comment\n\npick {three}",
+			"pick one parents=base, drop two, pick three", ""},
+		{"pick {one} parents={base},{three}\npick {two}\npick {three}",
+			"pick one parents=base,three, pick two, pick three", ""},
+		{"squash {one}", "", `unknown plan action "squash"`},
+		{"pick", "", "invalid plan line"},
+		{"pick {base}", "", "is not part of the rewrite"},
+		{"pick {one}\npick {one}", "", "appears twice in plan"},
+		{"pick 0000000000", "", " "},
+	}
+
+	for _, test := range tests {
+		plan, err := ReadPlan(tr.repo, strings.NewReader(planText(test.plan, ids)), commits)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expected error %q, got %v", test.plan, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.plan, err)
+			continue
+		}
+		steps := make([]string, len(plan))
+		for i, step := range plan {
+			steps[i] = fmt.Sprintf("%s %s", step.Action, subjects[step.Commit.Id().String()])
+			if step.Parents != nil {
+				parents := []string{}
+				for _, parent := range step.Parents {
+					parents = append(parents, subjects[parent.String()])
+				}
+				steps[i] += " parents=" + strings.Join(parents, ",")
+			}
+		}
+		if res := strings.Join(steps, ", "); res != test.expected {
+			t.Errorf("%q: expected %s, got %s", test.plan, test.expected, res)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	drop, err := ParseExpression(nil, `message =~ "^two"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		processor Processer
+		plan      string
+		expected  []string
+		b         string // content of file b once rewritten
+	}{
+		{"drop", &DropProcessor{drop}, "", []string{"base", "one", "three", "four"}, "1"},
+		{"reorder", &ComposerProcessor{}, "pick {one}\npick {three}\npick {four}\npick {two}",
+			[]string{"base", "one", "three", "four", "two"}, "2"},
+		// commits following a reordered one are replayed on top of it
+		{"reorder middle", &ComposerProcessor{}, "pick {one}\npick {three}\npick {two}\npick {four}",
+			[]string{"base", "one", "three", "two", "four"}, "2"},
+		{"removed line", &ComposerProcessor{}, "pick {one}\npick {three}\npick {four}",
+			[]string{"base", "one", "three", "four"}, "1"},
+		{"drop line", &ComposerProcessor{}, "pick {one}\ndrop {two}\npick {three}\npick {four}",
+			[]string{"base", "one", "three", "four"}, "1"},
+	}
+
+	for _, test := range tests {
+		tr := newTestRepo(t)
+		ids := planHistory(tr)
+
+		tr.rewrite(test.processor, &Options{Commits: -1, Range: ids["base"]}, func(h *Historiography) error {
+			commits := Flatten(h.Commits)
+			plan, err := h.Plan(commits)
+			if err == nil && test.plan != "" {
+				plan, err = ReadPlan(tr.repo, strings.NewReader(planText(test.plan, ids)), commits)
+			}
+			if err != nil {
+				return err
+			}
+			return h.Replay(plan)
+		})
+
+		// every kept commit is reachable from the new tip
+		expectStrings(t, test.name, test.expected, tr.subjects("HEAD"))
+		expectStrings(t, test.name+" count", []string{fmt.Sprint(len(test.expected))},
+			[]string{tr.git("rev-list", "--count", "HEAD")})
+		expectStrings(t, test.name+" content", []string{test.b, "1", "1"},
+			[]string{tr.git("show", "HEAD:b"), tr.git("show", "HEAD:c"), tr.git("show", "HEAD:d")})
+		tr.Free()
+	}
+}
+
+func TestReplayConflict(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.Free()
+	ids := planHistory(tr)
+
+	h, err := NewHistoriography(tr.repo, &ComposerProcessor{}, &Options{Commits: -1, Range: ids["base"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Free()
+
+	// two changes b before one creates it
+	plan, err := ReadPlan(tr.repo, strings.NewReader(planText("pick {two}\npick {one}\npick {three}\npick {four}", ids)),
+		Flatten(h.Commits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Replay(plan)
+	conflict, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if conflict.Commit.String() != ids["two"] || strings.Join(conflict.Paths, ",") != "b" {
+		t.Errorf("expected conflict of %s on b, got %s", ids["two"], err)
+	}
+}
@@ -0,0 +1,259 @@
+package historiography
+
+import (
+	"fmt"
+	git "gopkg.in/libgit2/git2go.v26"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Define a condition over commits, used to select which commits are altered
+// by processers. Commits which are not selected are replayed untouched.
+type Predicate interface {
+	// Indicates if the commit satisfies the condition.
+	Match(*git.Commit) (bool, error)
+}
+
+// Predicate satisfied when all embedded predicates are, an empty list matches
+// every commit.
+type AllPredicate []Predicate
+
+// Match commits satisfying every embedded predicates, evaluation stops at the
+// first predicate not satisfied.
+func (ap AllPredicate) Match(commit *git.Commit) (bool, error) {
+	for _, predicate := range ap {
+		if ok, err := predicate.Match(commit); !ok || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Select commits according to their author date.
+type DatePredicate struct {
+	// Commits authored before Since or after Until are not selected, zero
+	// values mean no limit.
+	Since, Until time.Time
+}
+
+// Match commits authored between Since and Until.
+func (dp *DatePredicate) Match(commit *git.Commit) (bool, error) {
+	date := commit.Author().When
+	if !dp.Since.IsZero() && date.Before(dp.Since) {
+		return false, nil
+	}
+	if !dp.Until.IsZero() && date.After(dp.Until) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Select commits according to their author, like git log --author does.
+type AuthorPredicate struct {
+	// Pattern applied on the author formatted as "Name <email>".
+	Pattern *regexp.Regexp
+}
+
+// Match commits for which the author matches Pattern.
+func (ap *AuthorPredicate) Match(commit *git.Commit) (bool, error) {
+	author := commit.Author()
+	return ap.Pattern.MatchString(fmt.Sprintf("%s <%s>", author.Name, author.Email)), nil
+}
+
+// Set of commits belonging to one or several revision ranges.
+type RevisionPredicate map[git.Oid]bool
+
+// Build a RevisionPredicate from revision specifications as understood by
+// git log, i.e: "A..B", "^X" to exclude X and its ancestors, or "Y" to include
+// Y and its ancestors.
+func NewRevisionPredicate(repo *git.Repository, specs ...string) (_ RevisionPredicate, err error) {
+	var rev *git.RevWalk
+
+	if rev, err = repo.Walk(); err != nil {
+		return
+	}
+	defer rev.Free()
+
+	for _, spec := range specs {
+		switch {
+		case strings.Contains(spec, ".."):
+			err = rev.PushRange(spec)
+		case strings.HasPrefix(spec, "^"):
+			var oid *git.Oid
+			if oid, err = revision(repo, spec[1:]); err == nil {
+				err = rev.Hide(oid)
+			}
+		default:
+			var oid *git.Oid
+			if oid, err = revision(repo, spec); err == nil {
+				err = rev.Push(oid)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid revision %q: %s", spec, err)
+		}
+	}
+
+	commits := setIterator{}
+	if err = rev.Iterate(commits.RevWalkIterator); err != nil {
+		return
+	}
+	return RevisionPredicate(commits), nil
+}
+
+// Resolve a revision to the id of the commit it points to.
+func revision(repo *git.Repository, spec string) (*git.Oid, error) {
+	obj, err := repo.RevparseSingle(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Free()
+
+	commit, err := obj.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+	defer commit.Free()
+	return commit.Id(), nil
+}
+
+// Match commits which belong to the revision ranges.
+func (rp RevisionPredicate) Match(commit *git.Commit) (bool, error) {
+	return rp[*commit.Id()], nil
+}
+
+// Select commits whose changes against their first parent touch paths matching
+// a pathspec, and a regexp if given.
+type PathPredicate struct {
+	// Repository the commits belong to.
+	Repo *git.Repository
+	// Pathspecs as understood by git, e.g: "docs/" or "vendor/*.go".
+	Paths []string
+	// Regexp touched paths must match, nil matches any path.
+	Pattern *regexp.Regexp
+}
+
+// Match commits touching at least one path of the pathspec, matching the
+// regexp if any.
+func (pp *PathPredicate) Match(commit *git.Commit) (_ bool, err error) {
+	var tree, parent *git.Tree
+	var diff *git.Diff
+	var nb int
+
+	opts, err := git.DefaultDiffOptions()
+	if err != nil {
+		return
+	}
+	opts.Pathspec = pp.Paths
+
+	if tree, err = commit.Tree(); err != nil {
+		return
+	}
+	defer tree.Free()
+
+	// root commits are compared to an empty tree
+	if commit.ParentCount() > 0 {
+		first := commit.Parent(0)
+		defer first.Free()
+		if parent, err = first.Tree(); err != nil {
+			return
+		}
+		defer parent.Free()
+	}
+
+	if diff, err = pp.Repo.DiffTreeToTree(parent, tree, &opts); err != nil {
+		return
+	}
+	defer diff.Free()
+
+	if nb, err = diff.NumDeltas(); err != nil || pp.Pattern == nil {
+		return nb > 0, err
+	}
+	for i := 0; i < nb; i++ {
+		var delta git.DiffDelta
+		if delta, err = diff.GetDelta(i); err != nil {
+			return
+		}
+		if pp.Pattern.MatchString(delta.NewFile.Path) || pp.Pattern.MatchString(delta.OldFile.Path) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Predicate satisfied when at least one of the embedded predicates is.
+type AnyPredicate []Predicate
+
+// Match commits satisfying one of the embedded predicates, evaluation stops at
+// the first predicate satisfied.
+func (ap AnyPredicate) Match(commit *git.Commit) (bool, error) {
+	for _, predicate := range ap {
+		if ok, err := predicate.Match(commit); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// Negate the embedded predicate.
+type NotPredicate struct {
+	Predicate Predicate
+}
+
+// Match commits not satisfying the embedded predicate.
+func (np *NotPredicate) Match(commit *git.Commit) (bool, error) {
+	ok, err := np.Predicate.Match(commit)
+	return !ok, err
+}
+
+// Textual field of a commit, used by predicates operating on strings.
+type Field int
+
+const (
+	AuthorName Field = iota
+	AuthorEmail
+	CommitterName
+	CommitterEmail
+	Message
+)
+
+// Retrieve the value of the field for a commit.
+func (f Field) Value(commit *git.Commit) string {
+	switch f {
+	case AuthorName:
+		return commit.Author().Name
+	case AuthorEmail:
+		return commit.Author().Email
+	case CommitterName:
+		return commit.Committer().Name
+	case CommitterEmail:
+		return commit.Committer().Email
+	default:
+		return commit.RawMessage()
+	}
+}
+
+// Select commits for which a field matches a regular expression.
+type RegexpPredicate struct {
+	Field   Field
+	Pattern *regexp.Regexp
+}
+
+// Match commits for which the field value matches Pattern.
+func (rp *RegexpPredicate) Match(commit *git.Commit) (bool, error) {
+	return rp.Pattern.MatchString(rp.Field.Value(commit)), nil
+}
+
+// Select commits according to their number of parents, e.g: merge commits
+// have at least 2 parents.
+type ParentsPredicate struct {
+	// Bounds of the number of parents, negative Max means no upper limit.
+	Min, Max int
+}
+
+// Match commits with a number of parents between Min and Max.
+func (pp *ParentsPredicate) Match(commit *git.Commit) (bool, error) {
+	nb := int(commit.ParentCount())
+	return nb >= pp.Min && (pp.Max < 0 || nb <= pp.Max), nil
+}
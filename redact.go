@@ -0,0 +1,146 @@
+package historiography
+
+import (
+	"fmt"
+	git "gopkg.in/libgit2/git2go.v26"
+	"io"
+	"math"
+	"regexp"
+)
+
+// Text replacing sensitive content.
+const Redacted = "***REDACTED***"
+
+// Detect sensitive content in messages.
+type Detector struct {
+	// Name displayed in reports.
+	Name string
+	// Pattern matching sensitive content.
+	Pattern *regexp.Regexp
+	// Minimal Shannon entropy, in bits per character, of a match to be
+	// considered sensitive. Zero disables the check.
+	Entropy float64
+}
+
+// Built-in detectors, specific ones first so generic ones (email, entropy)
+// do not hide what has been found.
+var Detectors = []Detector{
+	{"aws-access-key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`), 0},
+	{"github-token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`), 0},
+	{"slack-token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`), 0},
+	{"google-api-key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`), 0},
+	{"private-key", regexp.MustCompile(
+		`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`), 0},
+	{"jwt", regexp.MustCompile(
+		`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}\b`), 0},
+	{"secret-assignment", regexp.MustCompile(
+		`(?i)\b(api[_-]?key|token|secret|passw(or)?d)\s*[:=]\s*["']?[^\s"']{6,}`), 0},
+	{"email", regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`), 0},
+	{"high-entropy", regexp.MustCompile(`\b[A-Za-z0-9+/=_-]{20,}`), 4.0},
+}
+
+// Compute the Shannon entropy of a string in bits per character.
+func entropy(s string) (res float64) {
+	counts := map[rune]float64{}
+	for _, r := range s {
+		counts[r]++
+	}
+	total := float64(len([]rune(s)))
+	for _, count := range counts {
+		p := count / total
+		res -= p * math.Log2(p)
+	}
+	return
+}
+
+// Sensitive content found in a commit message.
+type Redaction struct {
+	// Commit in which content has been redacted.
+	Commit git.Oid
+	// Name of the detector which spotted the content.
+	Detector string
+	// Truncated content, safe to display.
+	Preview string
+}
+
+// Processor replacing sensitive content of commit messages by ***REDACTED***.
+// Redactions are recorded and can be displayed with Report.
+type RedactProcessor struct {
+	// Detectors to run on messages, in order.
+	Detectors []Detector
+	// Redactions performed, per commit.
+	Redactions map[git.Oid][]Redaction
+
+	order []git.Oid // commits in the order they were redacted
+}
+
+// Preprocess is no-op for RedactProcessor
+func (rp *RedactProcessor) Preprocess(_ Commits) error { return nil }
+
+// Redact commit message, author and committer are left untouched.
+func (rp *RedactProcessor) Process(commit *git.Commit) (a, c *git.Signature, m string, e error) {
+	a, c = commit.Author(), commit.Committer()
+	m, e = rp.FilterMessage(commit, commit.RawMessage())
+
+	return
+}
+
+// Show only the beginning of sensitive content.
+func preview(s string) string {
+	if r := []rune(s); len(r) > 4 {
+		return string(r[:4]) + "…"
+	}
+	return "…"
+}
+
+// Replace sensitive content of message m, redactions replace previous ones
+// recorded for the same commit. Trailers are left untouched, identities they
+// hold (e.g: Signed-off-by) are changed by TrailerProcessor.
+func (rp *RedactProcessor) FilterMessage(commit *git.Commit, m string) (string, error) {
+	redactions := []Redaction{}
+	start := trailersIndex(m)
+	body, trailers := m[:start], m[start:]
+	for _, detector := range rp.Detectors {
+		d := detector
+		body = d.Pattern.ReplaceAllStringFunc(body, func(match string) string {
+			if d.Entropy > 0 && entropy(match) < d.Entropy {
+				return match
+			}
+			redactions = append(redactions, Redaction{*commit.Id(), d.Name, preview(match)})
+			return Redacted
+		})
+	}
+	m = body + trailers
+
+	if rp.Redactions == nil {
+		rp.Redactions = map[git.Oid][]Redaction{}
+	}
+	if len(redactions) > 0 {
+		if _, ok := rp.Redactions[*commit.Id()]; !ok {
+			rp.order = append(rp.order, *commit.Id())
+		}
+		rp.Redactions[*commit.Id()] = redactions
+	} else {
+		delete(rp.Redactions, *commit.Id())
+	}
+	return m, nil
+}
+
+// Write a report of redactions performed, one line per redaction, in the
+// order commits were processed.
+func (rp *RedactProcessor) Report(w io.Writer) (err error) {
+	reported := map[git.Oid]bool{}
+	for _, oid := range rp.order {
+		if reported[oid] {
+			continue // redacted again after being cleared
+		}
+		reported[oid] = true
+		for _, redaction := range rp.Redactions[oid] {
+			if _, err = fmt.Fprintf(w, "commit %s: redacted %s (%s)\n",
+				oid.String()[:10], redaction.Detector, redaction.Preview); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,31 @@
+package historiography
+
+import "testing"
+
+func TestRedactTrailers(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.Free()
+	commit := tr.lookup(tr.commit(testDate, "base", "a", "1"))
+	rp := &RedactProcessor{Detectors: Detectors}
+
+	tests := []struct {
+		message, expected string
+	}{
+		{"subject\n\nwrite to a@x.org\n", "subject\n\nwrite to " + Redacted + "\n"},
+		// identities of trailers are left to TrailerProcessor
+		{"subject\n\nSigned-off-by: A <a@x.org>\nCo-authored-by: B <b@x.org>\n",
+			"subject\n\nSigned-off-by: A <a@x.org>\nCo-authored-by: B <b@x.org>\n"},
+		{"subject\n\nwrite to a@x.org\n\nSigned-off-by: A <a@x.org>\n",
+			"subject\n\nwrite to " + Redacted + "\n\nSigned-off-by: A <a@x.org>\n"},
+		// a last paragraph which is not made of trailers is redacted
+		{"subject\n\nSigned-off-by: A <a@x.org>\nby a@x.org\n",
+			"subject\n\nSigned-off-by: A <" + Redacted + ">\nby " + Redacted + "\n"},
+	}
+
+	for _, test := range tests {
+		res, err := rp.FilterMessage(commit, test.message)
+		if err != nil || res != test.expected {
+			t.Errorf("%q: expected %q, got %q (%v)", test.message, test.expected, res, err)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package historiography
+
+import (
+	"fmt"
+	git "gopkg.in/libgit2/git2go.v26"
+	"strings"
+)
+
+// Indicates what to do with references whose history contains rewritten
+// commits.
+type RefMode int
+
+const (
+	// Only report references, default.
+	ReportRefs RefMode = iota
+	// Move references pointing to a rewritten commit, references with commits
+	// of their own on top of rewritten history are only reported.
+	RepointRefs
+	// Replay commits of references on top of rewritten history.
+	RebaseRefs
+)
+
+// Reference whose history contains rewritten commits.
+type StaleRef struct {
+	Name string
+	// Target of the reference before and after the update.
+	Old, New git.Oid
+	// Commits of the reference which are not part of the rewritten history.
+	Commits int
+	// Indicates if the reference has been updated.
+	Updated bool
+	// Reason why the reference has not been updated.
+	Reason string
+}
+
+func (sr StaleRef) String() string {
+	if sr.Updated {
+		return fmt.Sprintf("%s: %s -> %s", sr.Name, sr.Old.String()[:10], sr.New.String()[:10])
+	}
+	return fmt.Sprintf("%s: %s (%s)", sr.Name, sr.Old.String()[:10], sr.Reason)
+}
+
+// References updated elsewhere: tags by RewriteTags, notes by CopyNotes, and
+// references of historiography itself.
+var ignoredRefs = []string{"refs/tags/", "refs/notes/", "refs/historiography/"}
+
+// Find references, other than the rewritten branch, whose history contains
+// rewritten commits and update them according to mode. Commits replayed when
+// rebasing are added to Rewritten, so tags and notes follow them. Must be
+// called after Override.
+func (h *Historiography) UpdateRefs(mode RefMode) (stale []StaleRef, err error) {
+	iterator, err := h.repo.NewReferenceIterator()
+	if err != nil {
+		return
+	}
+	defer iterator.Free()
+
+	// references can not be changed while iterating on them
+	refs := map[string]git.Oid{}
+	names := []string{}
+	for {
+		ref, err := iterator.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.ignored(ref) {
+			ref.Free()
+			continue
+		}
+		var obj *git.Object
+		if obj, err = ref.Peel(git.ObjectCommit); err == nil {
+			names, refs[ref.Name()] = append(names, ref.Name()), *obj.Id()
+			obj.Free()
+		}
+		ref.Free()
+	}
+
+	for _, name := range names {
+		var sr *StaleRef
+		if sr, err = h.updateRef(name, refs[name], mode); err != nil {
+			return
+		}
+		if sr != nil {
+			stale = append(stale, *sr)
+		}
+	}
+	return
+}
+
+// Indicates if a reference is out of the scope of UpdateRefs.
+func (h *Historiography) ignored(ref *git.Reference) bool {
+	if ref.Type() == git.ReferenceSymbolic {
+		return true
+	}
+	name := ref.Name()
+	if name == h.head.Name() || name == h.tmp.Name() {
+		return true
+	}
+	for _, prefix := range ignoredRefs {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the history of a reference and update it, returns nil if its history
+// does not contain rewritten commits.
+func (h *Historiography) updateRef(name string, target git.Oid, mode RefMode) (*StaleRef, error) {
+	sr := &StaleRef{Name: name, Old: target, New: target}
+
+	// pointing to a rewritten commit, the reference only has to be moved
+	if resolved := h.resolve(target); resolved != target {
+		if mode == ReportRefs {
+			sr.Reason = "points to a rewritten commit"
+			return sr, nil
+		}
+		sr.New = resolved
+		return sr, h.setRef(sr, "repoint "+name)
+	}
+
+	commits, stale, err := h.forked(target)
+	if err != nil || !stale {
+		return nil, err
+	}
+	sr.Commits = len(commits)
+	switch mode {
+	case ReportRefs:
+		sr.Reason = fmt.Sprintf("%d commits on top of rewritten history", sr.Commits)
+		return sr, nil
+	case RepointRefs:
+		sr.Reason = fmt.Sprintf("%d commits on top of rewritten history, rebase needed", sr.Commits)
+		return sr, nil
+	}
+
+	for _, commit := range commits {
+		if err = h.replay(commit); err != nil {
+			if _, ok := err.(*ConflictError); ok {
+				sr.Reason = err.Error()
+				return sr, nil
+			}
+			return nil, err
+		}
+	}
+	sr.New = h.resolve(target)
+	return sr, h.setRef(sr, "rebase "+name)
+}
+
+// Point a reference to its new target.
+func (h *Historiography) setRef(sr *StaleRef, action string) error {
+	_, err := h.repo.References.Create(sr.Name, &sr.New, true, h.reflog(action, &sr.Old))
+	sr.Updated = err == nil
+	return err
+}
+
+// Walk the history of target, stopping at rewritten history and at the base of
+// the rewrite. Returns commits which are not part of the rewrite, parents
+// first, and whether rewritten commits are reachable from target.
+func (h *Historiography) forked(target git.Oid) (commits Commits, stale bool, err error) {
+	rev, err := h.repo.Walk()
+	if err != nil {
+		return
+	}
+	defer rev.Free()
+	rev.Sorting(git.SortTopological | git.SortReverse)
+
+	if err = rev.Push(&target); err != nil {
+		return
+	}
+	if h.tip != nil {
+		if err = rev.Hide(h.tip); err != nil {
+			return
+		}
+	}
+	// parents of the rewritten commits out of the rewrite
+	for _, commit := range Flatten(h.Commits) {
+		for _, id := range parentIds(commit) {
+			if _, ok := h.Rewritten[id]; !ok {
+				if err = rev.Hide(&id); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	err = rev.Iterate(func(commit *git.Commit) bool {
+		if resolved, ok := h.Rewritten[*commit.Id()]; ok {
+			stale = stale || resolved != *commit.Id()
+		} else {
+			commits = append(commits, commit)
+		}
+		return true
+	})
+	return
+}
+
+// Replay a commit out of the rewrite on top of rewritten history. The commit
+// is left untouched but its parents are mapped to rewritten ones and its
+// changes merged on top of them.
+func (h *Historiography) replay(commit *git.Commit) error {
+	t, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	defer func() { t.Free() }()
+
+	parents, err := h.parents(commit, parentIds(commit))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, parent := range parents {
+			parent.Free()
+		}
+	}()
+
+	if h.rebased(commit, parents) {
+		merged, err := h.merge(commit, parents, t, false)
+		if err != nil {
+			return err
+		}
+		t.Free()
+		t = merged
+	}
+
+	id, err := h.create(commit, commit.Author(), commit.Committer(), commit.RawMessage(), t, parents)
+	if err != nil {
+		return err
+	}
+	h.Rewritten[*commit.Id()] = *id
+	return nil
+}
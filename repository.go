@@ -2,6 +2,7 @@ package historiography
 
 import (
 	"errors"
+	"fmt"
 	git "gopkg.in/libgit2/git2go.v26"
 	"time"
 )
@@ -43,10 +44,11 @@ func (ri *RetrieveIterator) RevWalkIterator(commit *git.Commit) bool {
 
 // Walk throught commits of a repo using RevWalk from libgit.
 // Commits are passed in reversed topologically order (parent first,
-// then children). The RevWalk is started over HEAD refs.
+// then children). The RevWalk is started over HEAD refs and stops at hidden
+// commits, which are excluded from the walk as well as their ancestors.
 // It use the RevWalkerIterator interface function: RevWalkIterator to walk
 // over commits.
-func RepoWalk(repo *git.Repository, rwi RevWalkerIterator) (err error) {
+func RepoWalk(repo *git.Repository, rwi RevWalkerIterator, hide ...*git.Oid) (err error) {
 	var rev *git.RevWalk
 
 	if rev, err = repo.Walk(); err != nil {
@@ -58,17 +60,151 @@ func RepoWalk(repo *git.Repository, rwi RevWalkerIterator) (err error) {
 	if err = rev.PushHead(); err != nil {
 		return
 	}
+	for _, oid := range hide {
+		if err = rev.Hide(oid); err != nil {
+			return
+		}
+	}
 	return rev.Iterate(rwi.RevWalkIterator)
 }
 
-// Retrieve all commits of the current repository branch.
+// Compute the commits at which the walk over HEAD has to stop according to
+// options. A revision range must end at HEAD, a single revision is understood
+// as REV..HEAD.
+func boundaries(repo *git.Repository, opts *Options) (hide []*git.Oid, err error) {
+	var head *git.Reference
+
+	if head, err = repo.Head(); err != nil {
+		return
+	}
+	defer head.Free()
+
+	if opts.Range != "" {
+		var spec *git.Revspec
+		if spec, err = repo.Revparse(opts.Range); err != nil {
+			return
+		}
+		switch {
+		case spec.Flags()&git.RevparseMergeBase != 0:
+			return nil, fmt.Errorf("symmetric range %q is not supported", opts.Range)
+		case spec.Flags()&git.RevparseRange != 0:
+			if !spec.To().Id().Equal(head.Target()) {
+				return nil, fmt.Errorf("range %q does not end at HEAD", opts.Range)
+			}
+		}
+		hide = append(hide, spec.From().Id())
+	}
+
+	if opts.Unpushed {
+		var base *git.Oid
+		if base, err = mergeBaseUpstream(head); err != nil {
+			return
+		}
+		hide = append(hide, base)
+	}
+	return
+}
+
+// Find the merge-base between a branch and its upstream tracking branch.
+func mergeBaseUpstream(head *git.Reference) (*git.Oid, error) {
+	if !head.IsBranch() {
+		return nil, errors.New("HEAD is detached, can not find its upstream")
+	}
+	upstream, err := head.Branch().Upstream()
+	if err != nil {
+		return nil, fmt.Errorf("branch %s has no upstream: %s", head.Shorthand(), err)
+	}
+	defer upstream.Free()
+
+	return head.Owner().MergeBase(head.Target(), upstream.Target())
+}
+
+// Retrieve commits of the current repository branch, restricted by options.
+// Commits preceding the first one matched by the filter, if any, are left out.
 // It internally use RepoWalk with an instance of a RetrieveIterator.
-func Retrieve(repo *git.Repository, nb int) ([]Commits, error) {
-	ri := RetrieveIterator{nb: nb}
-	err := RepoWalk(repo, &ri)
+func Retrieve(repo *git.Repository, opts *Options) ([]Commits, error) {
+	hide, err := boundaries(repo, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ri := RetrieveIterator{nb: opts.Commits}
+	if err = RepoWalk(repo, &ri, hide...); err != nil {
+		return nil, err
+	}
 
-	if err == nil && len(ri.Commits) == 0 {
+	// oldest commits not selected do not need to be replayed
+	if opts.Filter != nil {
+		if ri.Commits, err = trim(ri.Commits, opts.Filter); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ri.Commits) == 0 {
 		err = errors.New("there is not commit to process")
 	}
 	return ri.Commits, err
 }
+
+// Remove commits preceding the first one matched by the predicate and group
+// remaining commits per day again.
+func trim(commits []Commits, predicate Predicate) ([]Commits, error) {
+	flat := Flatten(commits)
+	for i, commit := range flat {
+		ok, err := predicate.Match(commit)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return group(flat[i:]), nil
+		}
+	}
+	return nil, nil
+}
+
+// Group a list of commits, from oldest to newest, per day.
+func group(commits Commits) []Commits {
+	var ri RetrieveIterator
+	for i := len(commits) - 1; i >= 0; i-- { // iterator expects newest first
+		ri.RevWalkIterator(commits[i])
+	}
+	return ri.Commits
+}
+
+// Iterator collecting ids of commits in a set.
+type setIterator map[git.Oid]bool
+
+func (si setIterator) RevWalkIterator(commit *git.Commit) bool {
+	si[*commit.Id()] = true
+	return true
+}
+
+// Return the first commit of the list which is reachable from a
+// remote-tracking ref, nil if none of them has been published.
+func Published(repo *git.Repository, commits Commits) (_ *git.Commit, err error) {
+	var rev *git.RevWalk
+
+	if rev, err = repo.Walk(); err != nil {
+		return
+	}
+	defer rev.Free()
+
+	// commits of HEAD which are not known by any remote
+	local := setIterator{}
+	if err = rev.PushHead(); err != nil {
+		return
+	}
+	if err = rev.HideGlob("refs/remotes/*"); err != nil {
+		return
+	}
+	if err = rev.Iterate(local.RevWalkIterator); err != nil {
+		return
+	}
+
+	for _, commit := range commits {
+		if !local[*commit.Id()] {
+			return commit, nil
+		}
+	}
+	return
+}
@@ -0,0 +1,147 @@
+package historiography
+
+import (
+	"bytes"
+	"fmt"
+	git "gopkg.in/libgit2/git2go.v26"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Sign the content of commits, returns an armored signature stored in the
+// gpgsig header of the commit.
+type Signer interface {
+	Sign(content []byte) (string, error)
+}
+
+// Signer using an OpenPGP key through gpg.
+type GPGSigner struct {
+	// Program to run, gpg by default.
+	Program string
+	// Key to sign with, default key of gpg if empty.
+	Key string
+}
+
+// Sign content with gpg.
+func (gs *GPGSigner) Sign(content []byte) (string, error) {
+	args := []string{"--status-fd=2", "-bsa"}
+	if gs.Key != "" {
+		args = append(args, "-u", gs.Key)
+	}
+	return sign(program(gs.Program, "gpg"), args, content)
+}
+
+// Signer using an SSH key through ssh-keygen.
+type SSHSigner struct {
+	// Program to run, ssh-keygen by default.
+	Program string
+	// Path of the private key, or of the public key if the private one is
+	// held by an agent. Public keys can also be given literally, e.g:
+	// "key::ssh-ed25519 AAAA..." or "ssh-ed25519 AAAA...".
+	Key string
+}
+
+// Sign content with ssh-keygen.
+func (ss *SSHSigner) Sign(content []byte) (string, error) {
+	key := ss.Key
+	if literal := strings.TrimPrefix(key, "key::"); literal != key || strings.HasPrefix(key, "ssh-") {
+		f, err := ioutil.TempFile("", "historiography-key")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(f.Name())
+		_, err = f.WriteString(literal + "\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", err
+		}
+		key = f.Name()
+	}
+	return sign(program(ss.Program, "ssh-keygen"), []string{"-Y", "sign", "-n", "git", "-f", key}, content)
+}
+
+// Returns the program, or the default one if empty.
+func program(name, def string) string {
+	if name == "" {
+		return def
+	}
+	return name
+}
+
+// Run a signing program, content is written on its standard input and the
+// signature read from its standard output.
+func sign(program string, args []string, content []byte) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(program, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(content), &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("signing with %s failed: %s: %s",
+			program, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// Lookup a configuration value, missing values are empty.
+func lookup(config *git.Config, name string) (string, error) {
+	value, err := config.LookupString(name)
+	if git.IsErrorCode(err, git.ErrNotFound) {
+		return "", nil
+	}
+	return value, err
+}
+
+// Build the signer configured for the repository, the way git does:
+// gpg.format selects the kind of key (openpgp or ssh), user.signingkey the key,
+// and gpg.program or gpg.ssh.program the program to run.
+func NewSigner(repo *git.Repository) (Signer, error) {
+	config, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	defer config.Free()
+
+	values := map[string]string{}
+	for _, name := range []string{"gpg.format", "user.signingkey", "gpg.program", "gpg.ssh.program"} {
+		if values[name], err = lookup(config, name); err != nil {
+			return nil, err
+		}
+	}
+
+	switch values["gpg.format"] {
+	case "", "openpgp":
+		return &GPGSigner{values["gpg.program"], values["user.signingkey"]}, nil
+	case "ssh":
+		if values["user.signingkey"] == "" {
+			return nil, fmt.Errorf("user.signingkey is required to sign with ssh keys")
+		}
+		return &SSHSigner{values["gpg.ssh.program"], values["user.signingkey"]}, nil
+	}
+	return nil, fmt.Errorf("unsupported gpg.format %q, expected openpgp or ssh", values["gpg.format"])
+}
+
+// Indicates if a commit header holds a signature.
+func signature(h header) bool {
+	return h.Key == "gpgsig" || h.Key == "gpgsig-sha256"
+}
+
+// Returns commits carrying a signature, signatures are invalidated by the
+// rewrite.
+func Signed(repo *git.Repository, commits Commits) (signed Commits, err error) {
+	for _, commit := range commits {
+		var headers []header
+		if headers, err = readHeaders(repo, commit.Id()); err != nil {
+			return
+		}
+		for _, h := range headers {
+			if signature(h) {
+				signed = append(signed, commit)
+				break
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,167 @@
+package historiography
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Generate a throwaway SSH key in dir and the allowed signers file trusting it
+// for principal, returns their paths. The test is skipped without ssh-keygen.
+func sshKey(t *testing.T, dir, principal string) (key, allowed string) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not available")
+	}
+	key = filepath.Join(dir, "id_ed25519")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", principal,
+		"-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("generating key: %s\n%s", err, out)
+	}
+	public, err := ioutil.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed = filepath.Join(dir, "allowed_signers")
+	if err = ioutil.WriteFile(allowed, []byte(principal+" "+string(public)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestSSHSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "historiography")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, allowed := sshKey(t, dir, "committer@example.com")
+
+	content := []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n\nmessage\n")
+	signature, err := (&SSHSigner{Key: key}).Sign(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(signature, "-----BEGIN SSH SIGNATURE-----") {
+		t.Fatalf("expected an armored SSH signature, got %q", signature)
+	}
+
+	// the signature holds for the signed content only
+	path := filepath.Join(dir, "signature")
+	if err = ioutil.WriteFile(path, []byte(signature), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		content []byte
+		valid   bool
+	}{
+		{content, true},
+		{append(content, "tampered\n"...), false},
+	} {
+		cmd := exec.Command("ssh-keygen", "-Y", "verify", "-f", allowed,
+			"-I", "committer@example.com", "-n", "git", "-s", path)
+		cmd.Stdin = bytes.NewReader(test.content)
+		if out, err := cmd.CombinedOutput(); (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %t, got %s\n%s", test.content, test.valid, err, out)
+		}
+	}
+
+	if _, err = (&SSHSigner{Key: filepath.Join(dir, "missing")}).Sign(content); err == nil ||
+		!strings.Contains(err.Error(), "signing with ssh-keygen failed") {
+		t.Errorf("expected signing failure with a missing key, got %v", err)
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	tests := []struct {
+		config   []string
+		expected Signer
+		err      string
+	}{
+		{nil, &GPGSigner{}, ""},
+		{[]string{"gpg.program", "gpg2", "user.signingkey", "ABCD"}, &GPGSigner{"gpg2", "ABCD"}, ""},
+		{[]string{"gpg.format", "openpgp"}, &GPGSigner{}, ""},
+		{[]string{"gpg.format", "ssh", "user.signingkey", "/key"}, &SSHSigner{"", "/key"}, ""},
+		{[]string{"gpg.format", "ssh", "user.signingkey", "/key", "gpg.ssh.program", "keygen"},
+			&SSHSigner{"keygen", "/key"}, ""},
+		{[]string{"gpg.format", "ssh"}, nil, "user.signingkey is required"},
+		{[]string{"gpg.format", "x509"}, nil, `unsupported gpg.format "x509"`},
+	}
+
+	for _, test := range tests {
+		tr := newTestRepo(t)
+		for i := 0; i+1 < len(test.config); i += 2 {
+			tr.git("config", test.config[i], test.config[i+1])
+		}
+		signer, err := NewSigner(tr.repo)
+		tr.Free()
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expected error %q, got %v", test.config, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.config, err)
+			continue
+		}
+		if !equalSigners(signer, test.expected) {
+			t.Errorf("%q: expected %#v, got %#v", test.config, test.expected, signer)
+		}
+	}
+}
+
+// Compare signers by value.
+func equalSigners(s1, s2 Signer) bool {
+	switch s1 := s1.(type) {
+	case *GPGSigner:
+		s2, ok := s2.(*GPGSigner)
+		return ok && *s1 == *s2
+	case *SSHSigner:
+		s2, ok := s2.(*SSHSigner)
+		return ok && *s1 == *s2
+	}
+	return false
+}
+
+func TestSignRewrite(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.Free()
+	key, allowed := sshKey(t, filepath.Join(tr.dir, ".git"), "committer@example.com")
+	tr.git("config", "gpg.format", "ssh")
+	tr.git("config", "user.signingkey", key)
+	tr.git("config", "gpg.ssh.allowedSignersFile", allowed)
+
+	tr.commit(testDate, "base", "a", "1")
+	tr.commit(testDate.Add(1), "one", "b", "1")
+	tr.commit(testDate.Add(2), "two", "b", "2")
+
+	signer, err := NewSigner(tr.repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.rewrite(&ComposerProcessor{}, &Options{Commits: -1, Range: "master~2", Sign: signer},
+		func(h *Historiography) error { return h.Process(Flatten(h.Commits)) })
+
+	// rewritten commits are signed with the key, the base is left untouched
+	for _, rev := range []string{"master", "master~"} {
+		tr.git("verify-commit", rev)
+	}
+	cmd := exec.Command("git", "verify-commit", "master~2")
+	cmd.Dir, cmd.Env = tr.dir, tr.env()
+	if out, err := cmd.CombinedOutput(); err == nil {
+		t.Errorf("expected base commit to be unsigned, got %s", out)
+	}
+	signed, err := Signed(tr.repo, Commits{tr.lookup(tr.git("rev-parse", "master")),
+		tr.lookup(tr.git("rev-parse", "master~")), tr.lookup(tr.git("rev-parse", "master~2"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signed) != 2 {
+		t.Errorf("expected 2 signed commits, got %d", len(signed))
+	}
+}
@@ -0,0 +1,154 @@
+package historiography
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/glog"
+	git "gopkg.in/libgit2/git2go.v26"
+	"strings"
+)
+
+// Options of the rewrite of tags.
+type TagOptions struct {
+	// Mapping applied on taggers of annotated tags, taggers are handled as
+	// committers (e.g: see NameProcessor.Map). Can be nil.
+	Identities IdentityMapper
+	// Shift tagger dates of annotated tags by the date change of the tagged
+	// commit, so tags follow the date rules.
+	Dates bool
+}
+
+// Reference of a tag and the object it points to.
+type tagRef struct {
+	name   string
+	target git.Oid // id the reference points to
+}
+
+// Move tags pointing to rewritten commits on their rewritten counterparts.
+// Lightweight tags are moved, annotated tags are recreated with the same name,
+// tagger and message. Signatures of annotated tags are invalidated and removed.
+// Returns names of the tags rewritten.
+func (h *Historiography) RewriteTags(opts TagOptions) (names []string, err error) {
+	// references can not be changed while iterating on them
+	refs := []tagRef{}
+	err = h.repo.Tags.Foreach(func(name string, id *git.Oid) error {
+		refs = append(refs, tagRef{name, *id})
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	for _, ref := range refs {
+		var ok bool
+		if ok, err = h.rewriteTag(ref, &opts); err != nil {
+			return
+		}
+		if ok {
+			names = append(names, strings.TrimPrefix(ref.name, "refs/tags/"))
+		}
+	}
+	return
+}
+
+// Rewrite a tag if it points to a rewritten commit.
+func (h *Historiography) rewriteTag(ref tagRef, opts *TagOptions) (bool, error) {
+	obj, err := h.repo.Lookup(&ref.target)
+	if err != nil {
+		return false, err
+	}
+	defer obj.Free()
+
+	id := ref.target
+	switch obj.Type() {
+	case git.ObjectCommit:
+		target := h.resolve(ref.target)
+		if target == ref.target {
+			return false, nil
+		}
+		id = target
+	case git.ObjectTag:
+		tag, err := obj.AsTag()
+		if err != nil {
+			return false, err
+		}
+		if tag.TargetType() != git.ObjectCommit {
+			return false, nil // tags of trees, blobs or tags are left alone
+		}
+		target := h.resolve(*tag.TargetId())
+		if target == *tag.TargetId() {
+			return false, nil
+		}
+		created, err := h.recreateTag(tag, &target, opts)
+		if err != nil {
+			return false, err
+		}
+		id = *created
+	default:
+		return false, nil
+	}
+
+	_, err = h.repo.References.Create(ref.name, &id, true, h.reflog("rewrite tag", &ref.target))
+	return err == nil, err
+}
+
+// Signature blocks appended to messages of signed tags.
+var tagSignatures = []string{
+	"-----BEGIN PGP SIGNATURE-----", "-----BEGIN SSH SIGNATURE-----",
+	"-----BEGIN SIGNED MESSAGE-----",
+}
+
+// Create a copy of an annotated tag pointing to target, returns its id.
+func (h *Historiography) recreateTag(tag *git.Tag, target *git.Oid, opts *TagOptions) (*git.Oid, error) {
+	message := tag.Message()
+	for _, block := range tagSignatures {
+		if i := strings.Index(message, block); i >= 0 {
+			glog.Warningf("tag %s is signed, its signature is removed", tag.Name())
+			message = message[:i]
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "object %s\ntype commit\ntag %s\n", target, tag.Name())
+	if tagger := tag.Tagger(); tagger != nil {
+		tagger, err := h.tagger(tagger, tag.TargetId(), target, opts)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "tagger %s\n", formatSignature(tagger))
+	}
+	fmt.Fprintf(&buf, "\n%s", message)
+
+	odb, err := h.repo.Odb()
+	if err != nil {
+		return nil, err
+	}
+	defer odb.Free()
+	return odb.Write(buf.Bytes(), git.ObjectTag)
+}
+
+// Returns the tagger of a recreated tag, mapped and moved in time according
+// to the options.
+func (h *Historiography) tagger(tagger *git.Signature, old, new *git.Oid, opts *TagOptions) (*git.Signature, error) {
+	res := *tagger
+	if opts.Identities != nil {
+		res = *opts.Identities.Map(&res)
+	}
+	if !opts.Dates {
+		return &res, nil
+	}
+
+	before, err := h.repo.LookupCommit(old)
+	if err != nil {
+		return nil, err
+	}
+	defer before.Free()
+	after, err := h.repo.LookupCommit(new)
+	if err != nil {
+		return nil, err
+	}
+	defer after.Free()
+
+	res.When = res.When.Add(after.Committer().When.Sub(before.Committer().When))
+	return &res, nil
+}
@@ -0,0 +1,224 @@
+package historiography
+
+import (
+	"fmt"
+	git "gopkg.in/libgit2/git2go.v26"
+	"regexp"
+	"strings"
+)
+
+// Git trailer, e.g: "Signed-off-by: Name <email>".
+type Trailer struct {
+	Key, Value string
+}
+
+// Format the trailer as it appears in commit messages.
+func (t Trailer) String() string {
+	return fmt.Sprintf("%s: %s", t.Key, t.Value)
+}
+
+var (
+	trailerLine   = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9-]*)\s*:\s*(.*)$`)
+	identityValue = regexp.MustCompile(`^(.*?)\s*<([^>]*)>$`)
+)
+
+// Split a commit message in its body and trailers. Trailers are read from the
+// last paragraph of the message, which must only contain trailers (values can
+// be continued on lines starting with whitespaces).
+func ParseTrailers(m string) (body string, trailers []Trailer) {
+	m = strings.TrimRight(m, "\n")
+	start := trailersIndex(m)
+	if start == len(m) {
+		return m + "\n", nil
+	}
+
+	for _, line := range strings.Split(m[start:], "\n") {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			trailers[len(trailers)-1].Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		match := trailerLine.FindStringSubmatch(line)
+		trailers = append(trailers, Trailer{match[1], strings.TrimSpace(match[2])})
+	}
+
+	return strings.TrimRight(m[:start], "\n"), trailers
+}
+
+// Index of the paragraph of trailers ending message m, len(m) if the last
+// paragraph is not made of trailers, see ParseTrailers.
+func trailersIndex(m string) int {
+	trimmed := strings.TrimRight(m, "\n")
+	start := strings.LastIndex(trimmed, "\n\n")
+	if start < 0 { // a single paragraph is a subject, not trailers
+		return len(m)
+	}
+	start += 2
+
+	for i, line := range strings.Split(trimmed[start:], "\n") {
+		if i > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			continue
+		}
+		if !trailerLine.MatchString(line) {
+			return len(m)
+		}
+	}
+	return start
+}
+
+// Build a commit message from a body and trailers, separated by a blank line.
+func FormatTrailers(body string, trailers []Trailer) string {
+	lines := []string{}
+	if body = strings.TrimRight(body, "\n"); body != "" {
+		lines = append(lines, body)
+	}
+	if len(trailers) > 0 {
+		block := make([]string, len(trailers))
+		for i, trailer := range trailers {
+			block[i] = trailer.String()
+		}
+		lines = append(lines, strings.Join(block, "\n"))
+	}
+	return strings.Join(lines, "\n\n") + "\n"
+}
+
+// Renaming of the key of trailers.
+type TrailerRename struct {
+	Old, New string
+}
+
+// Processor managing git trailers of commit messages. Changes are applied in
+// order: removal, renaming, identities rewriting, additions and sign-off.
+type TrailerProcessor struct {
+	// Trailers appended to messages, unless already present with the same value.
+	Add []Trailer
+	// Keys of trailers to remove, compared case insensitively.
+	Remove []string
+	// Keys of trailers to rename, old keys are compared case insensitively.
+	// The first renaming of a key applies.
+	Rename []TrailerRename
+	// Add a Signed-off-by trailer for the (rewritten) author of the commit.
+	SignOff bool
+	// Processer rewriting signatures, trailer identities equal to the original
+	// author or committer are replaced by the rewritten ones. Can be nil.
+	Signatures Processer
+	// Mapping applied on all trailer identities ("Name <email>"). Can be nil.
+	Identities IdentityMapper
+}
+
+// Preprocess is no-op for TrailerProcessor
+func (tp *TrailerProcessor) Preprocess(_ Commits) error { return nil }
+
+// Change commit message trailers, author and committer are left untouched.
+func (tp *TrailerProcessor) Process(commit *git.Commit) (a, c *git.Signature, m string, e error) {
+	a, c = commit.Author(), commit.Committer()
+	m, e = tp.FilterMessage(commit, commit.RawMessage())
+
+	return
+}
+
+// Check if key is in keys, case insensitively.
+func hasKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare signatures identities, dates are not taken into account.
+func sameIdentity(s1, s2 *git.Signature) bool {
+	return s1.Name == s2.Name && strings.EqualFold(s1.Email, s2.Email)
+}
+
+// Rewrite trailers of message m.
+func (tp *TrailerProcessor) FilterMessage(commit *git.Commit, m string) (string, error) {
+	body, trailers := ParseTrailers(m)
+
+	// retrieve rewritten author and committer
+	author, committer := commit.Author(), commit.Committer()
+	if tp.Signatures != nil {
+		var err error
+		if author, committer, _, err = tp.Signatures.Process(commit); err != nil {
+			return "", err
+		}
+	}
+
+	// rewrite a trailer identity according to author and committer changes,
+	// which already went through the mapping, or else to the mapping
+	rewrite := func(sig *git.Signature) *git.Signature {
+		switch {
+		case sameIdentity(sig, commit.Author()):
+			return &git.Signature{Name: author.Name, Email: author.Email}
+		case sameIdentity(sig, commit.Committer()):
+			return &git.Signature{Name: committer.Name, Email: committer.Email}
+		case tp.Identities != nil:
+			return tp.Identities.Map(sig)
+		}
+		return sig
+	}
+
+	res := []Trailer{}
+	for _, trailer := range trailers {
+		if hasKey(tp.Remove, trailer.Key) {
+			continue
+		}
+		for _, rename := range tp.Rename {
+			if strings.EqualFold(rename.Old, trailer.Key) {
+				trailer.Key = rename.New
+				break
+			}
+		}
+		if match := identityValue.FindStringSubmatch(trailer.Value); match != nil {
+			sig := rewrite(&git.Signature{Name: match[1], Email: match[2]})
+			trailer.Value = fmt.Sprintf("%s <%s>", sig.Name, sig.Email)
+		}
+		res = append(res, trailer)
+	}
+
+	add := tp.Add
+	if tp.SignOff {
+		add = append(add[:len(add):len(add)], Trailer{
+			"Signed-off-by", fmt.Sprintf("%s <%s>", author.Name, author.Email),
+		})
+	}
+	for _, trailer := range add {
+		present := false
+		for _, t := range res {
+			if strings.EqualFold(t.Key, trailer.Key) && t.Value == trailer.Value {
+				present = true
+				break
+			}
+		}
+		if !present {
+			res = append(res, trailer)
+		}
+	}
+
+	if equalTrailers(res, trailers) {
+		return m, nil // nothing changed, keep message as is
+	}
+	return FormatTrailers(body, res), nil
+}
+
+// Compare two lists of trailers.
+func equalTrailers(t1, t2 []Trailer) bool {
+	if len(t1) != len(t2) {
+		return false
+	}
+	for i := range t1 {
+		if t1[i] != t2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse a trailer written as "Key: value".
+func ParseTrailer(s string) (Trailer, error) {
+	match := trailerLine.FindStringSubmatch(s)
+	if match == nil {
+		return Trailer{}, fmt.Errorf("invalid trailer %q, expected \"Key: value\"", s)
+	}
+	return Trailer{match[1], strings.TrimSpace(match[2])}, nil
+}
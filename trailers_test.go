@@ -0,0 +1,91 @@
+package historiography
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTrailers(t *testing.T) {
+	tests := []struct {
+		message, body string
+		trailers      []Trailer
+	}{
+		{"subject\n", "subject\n", nil},
+		{"Signed-off-by: A <a@x>\n", "Signed-off-by: A <a@x>\n", nil},
+		{"subject\n\nbody\n", "subject\n\nbody\n", nil},
+		{
+			"subject\n\nSigned-off-by: A <a@x>\n",
+			"subject", []Trailer{{"Signed-off-by", "A <a@x>"}},
+		},
+		{
+			"subject\n\nbody\n\nFixes: #12\nCo-authored-by:B <b@x>\n\n",
+			"subject\n\nbody", []Trailer{{"Fixes", "#12"}, {"Co-authored-by", "B <b@x>"}},
+		},
+		{
+			"subject\n\nNote: first line\n  continued\n\tagain\n",
+			"subject", []Trailer{{"Note", "first line continued again"}},
+		},
+		{"subject\n\nFixes: #12\nnot a trailer\n", "subject\n\nFixes: #12\nnot a trailer\n", nil},
+		{"subject\n\n  Fixes: #12\n", "subject\n\n  Fixes: #12\n", nil},
+	}
+
+	for _, test := range tests {
+		body, trailers := ParseTrailers(test.message)
+		if body != test.body || !reflect.DeepEqual(trailers, test.trailers) {
+			t.Errorf("%q: expected %q %v, got %q %v", test.message, test.body, test.trailers, body, trailers)
+		}
+	}
+}
+
+func TestFormatTrailers(t *testing.T) {
+	tests := []struct {
+		body     string
+		trailers []Trailer
+		expected string
+	}{
+		{"subject\n", nil, "subject\n"},
+		{"subject", []Trailer{{"Fixes", "#12"}}, "subject\n\nFixes: #12\n"},
+		{"subject\n\nbody\n\n", []Trailer{{"A", "1"}, {"B", "2"}}, "subject\n\nbody\n\nA: 1\nB: 2\n"},
+		{"", []Trailer{{"Fixes", "#12"}}, "Fixes: #12\n"},
+	}
+
+	for _, test := range tests {
+		if res := FormatTrailers(test.body, test.trailers); res != test.expected {
+			t.Errorf("%q %v: expected %q, got %q", test.body, test.trailers, test.expected, res)
+		}
+		// formatted messages parse back to the same trailers
+		if _, trailers := ParseTrailers(FormatTrailers(test.body, test.trailers)); test.body != "" &&
+			!equalTrailers(trailers, test.trailers) {
+			t.Errorf("%q %v: parsed back as %v", test.body, test.trailers, trailers)
+		}
+	}
+}
+
+func TestParseTrailer(t *testing.T) {
+	tests := []struct {
+		trailer  string
+		expected Trailer
+		err      string
+	}{
+		{"Reviewed-by: A <a@x>", Trailer{"Reviewed-by", "A <a@x>"}, ""},
+		{"Fixes:#12 ", Trailer{"Fixes", "#12"}, ""},
+		{"Key :", Trailer{"Key", ""}, ""},
+		{"no trailer", Trailer{}, "invalid trailer"},
+		{"-Key: value", Trailer{}, "invalid trailer"},
+		{"Key value: x", Trailer{}, "invalid trailer"},
+	}
+
+	for _, test := range tests {
+		trailer, err := ParseTrailer(test.trailer)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expected error %q, got %v", test.trailer, test.err, err)
+			}
+			continue
+		}
+		if err != nil || trailer != test.expected {
+			t.Errorf("%q: expected %v, got %v (%v)", test.trailer, test.expected, trailer, err)
+		}
+	}
+}
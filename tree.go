@@ -0,0 +1,288 @@
+package historiography
+
+import (
+	"bytes"
+	git "gopkg.in/libgit2/git2go.v26"
+	"path"
+	"strings"
+)
+
+// Processers implementing TreeProcesser can change the content of commits, the
+// tree returned replaces the tree of the commit when it is rewritten.
+type TreeProcesser interface {
+	// Returns the tree to use for commit. When composed, tree is the one
+	// produced by previous processers, otherwise it is the tree of the commit.
+	ProcessTree(commit *git.Commit, tree *git.Tree) (*git.Tree, error)
+}
+
+// Id of the empty tree, directories left empty are removed from trees.
+var emptyTree, _ = git.NewOid("4b825dc642cb6eb9a060e54bf8d69288fbee4904")
+
+// Decide the new content of a blob, or the new commit of a submodule, returns
+// a nil id to remove it.
+type blobRewriter func(path string, entry *git.TreeEntry) (*git.Oid, error)
+
+// Cache of rewritten trees, indexed by directory and tree id. Trees rarely
+// change between commits, the cache avoids rewriting them again.
+type treeCache map[string]*git.Oid
+
+// Rewrite a tree applying fn on each blob and links on each submodule entry
+// (gitlink), recursively. Directories left empty are removed, submodules are
+// kept as is if links is nil.
+func rewriteTree(repo *git.Repository, tree *git.Tree, dir string,
+	fn, links blobRewriter, cache treeCache) (_ *git.Oid, err error) {
+
+	key := dir + ":" + tree.Id().String()
+	if oid, ok := cache[key]; ok {
+		return oid, nil
+	}
+
+	builder, err := repo.TreeBuilderFromTree(tree)
+	if err != nil {
+		return
+	}
+	defer builder.Free()
+
+	for i := uint64(0); i < tree.EntryCount(); i++ {
+		entry := tree.EntryByIndex(i)
+		var oid *git.Oid
+
+		switch entry.Type {
+		case git.ObjectTree:
+			var sub *git.Tree
+			if sub, err = repo.LookupTree(entry.Id); err != nil {
+				return
+			}
+			oid, err = rewriteTree(repo, sub, dir+entry.Name+"/", fn, links, cache)
+			sub.Free()
+			if oid != nil && oid.Equal(emptyTree) {
+				oid = nil
+			}
+		case git.ObjectBlob:
+			oid, err = fn(dir+entry.Name, entry)
+		case git.ObjectCommit:
+			oid = entry.Id
+			if links != nil {
+				oid, err = links(dir+entry.Name, entry)
+			}
+		default:
+			oid = entry.Id
+		}
+		if err != nil {
+			return
+		}
+
+		switch {
+		case oid == nil:
+			err = builder.Remove(entry.Name)
+		case !oid.Equal(entry.Id):
+			err = builder.Insert(entry.Name, oid, entry.Filemode)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	oid, err := builder.Write()
+	if err == nil {
+		cache[key] = oid
+	}
+	return oid, err
+}
+
+// Rewrite a commit tree with fn and lookup the result.
+func processTree(repo *git.Repository, tree *git.Tree, fn blobRewriter, cache *treeCache) (*git.Tree, error) {
+	return processLinks(repo, tree, fn, nil, cache)
+}
+
+// Rewrite a commit tree with fn and links, see rewriteTree, and lookup the
+// result.
+func processLinks(repo *git.Repository, tree *git.Tree, fn, links blobRewriter,
+	cache *treeCache) (*git.Tree, error) {
+	if *cache == nil {
+		*cache = treeCache{}
+	}
+	oid, err := rewriteTree(repo, tree, "", fn, links, *cache)
+	if err != nil {
+		return nil, err
+	}
+	return repo.LookupTree(oid)
+}
+
+// Processor removing files from commits. Patterns are matched with path.Match
+// against the path of files and of their parent directories, patterns without
+// slash are matched against names only, e.g: "*.log" or "vendor/big".
+type RemovePathsProcessor struct {
+	Repo     *git.Repository
+	Patterns []string
+	cache    treeCache
+}
+
+// Preprocess is no-op for RemovePathsProcessor
+func (rp *RemovePathsProcessor) Preprocess(_ Commits) error { return nil }
+
+// Process is no-op for RemovePathsProcessor, only trees are changed.
+func (rp *RemovePathsProcessor) Process(commit *git.Commit) (a, c *git.Signature, m string, e error) {
+	return commit.Author(), commit.Committer(), commit.RawMessage(), nil
+}
+
+// Indicates if a path, or one of its parent directories, matches a pattern.
+func (rp *RemovePathsProcessor) match(p string) bool {
+	for ; p != "." && p != "/"; p = path.Dir(p) {
+		for _, pattern := range rp.Patterns {
+			name := p
+			if !strings.Contains(pattern, "/") {
+				name = path.Base(p)
+			}
+			if ok, _ := path.Match(strings.Trim(pattern, "/"), name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Remove files matching patterns from the tree.
+func (rp *RemovePathsProcessor) ProcessTree(_ *git.Commit, tree *git.Tree) (*git.Tree, error) {
+	return processTree(rp.Repo, tree, func(p string, entry *git.TreeEntry) (*git.Oid, error) {
+		if rp.match(p) {
+			return nil, nil
+		}
+		return entry.Id, nil
+	}, &rp.cache)
+}
+
+// Processor removing files bigger than a size limit from commits.
+type BlobSizeProcessor struct {
+	Repo *git.Repository
+	// Maximal size of files, in bytes.
+	Limit int64
+	cache treeCache
+	sizes map[git.Oid]uint64
+}
+
+// Preprocess is no-op for BlobSizeProcessor
+func (bp *BlobSizeProcessor) Preprocess(_ Commits) error { return nil }
+
+// Process is no-op for BlobSizeProcessor, only trees are changed.
+func (bp *BlobSizeProcessor) Process(commit *git.Commit) (a, c *git.Signature, m string, e error) {
+	return commit.Author(), commit.Committer(), commit.RawMessage(), nil
+}
+
+// Returns the size of a blob, read from its header only.
+func (bp *BlobSizeProcessor) size(odb *git.Odb, id *git.Oid) (uint64, error) {
+	if size, ok := bp.sizes[*id]; ok {
+		return size, nil
+	}
+	size, _, err := odb.ReadHeader(id)
+	if err != nil {
+		return 0, err
+	}
+	bp.sizes[*id] = size
+	return size, nil
+}
+
+// Remove files bigger than Limit from the tree.
+func (bp *BlobSizeProcessor) ProcessTree(_ *git.Commit, tree *git.Tree) (*git.Tree, error) {
+	if bp.sizes == nil {
+		bp.sizes = map[git.Oid]uint64{}
+	}
+	odb, err := bp.Repo.Odb()
+	if err != nil {
+		return nil, err
+	}
+	defer odb.Free()
+	return processTree(bp.Repo, tree, func(_ string, entry *git.TreeEntry) (*git.Oid, error) {
+		size, err := bp.size(odb, entry.Id)
+		if err != nil {
+			return nil, err
+		}
+		if int64(size) > bp.Limit {
+			return nil, nil
+		}
+		return entry.Id, nil
+	}, &bp.cache)
+}
+
+// Processor replacing content of text files in commits, binary files (files
+// containing a NUL byte) are left untouched.
+type ReplaceContentProcessor struct {
+	Repo         *git.Repository
+	Replacements []Replacement
+	cache        treeCache
+	blobs        map[git.Oid]*git.Oid
+}
+
+// Preprocess is no-op for ReplaceContentProcessor
+func (rp *ReplaceContentProcessor) Preprocess(_ Commits) error { return nil }
+
+// Process is no-op for ReplaceContentProcessor, only trees are changed.
+func (rp *ReplaceContentProcessor) Process(commit *git.Commit) (a, c *git.Signature, m string, e error) {
+	return commit.Author(), commit.Committer(), commit.RawMessage(), nil
+}
+
+// Apply replacements on a blob, returns the id of the new blob.
+func (rp *ReplaceContentProcessor) replace(id *git.Oid) (*git.Oid, error) {
+	if oid, ok := rp.blobs[*id]; ok {
+		return oid, nil
+	}
+
+	blob, err := rp.Repo.LookupBlob(id)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Free()
+
+	oid, content := id, blob.Contents()
+	if bytes.IndexByte(content, 0) < 0 {
+		res := content
+		for _, replacement := range rp.Replacements {
+			res = replacement.Pattern.ReplaceAll(res, []byte(replacement.Replace))
+		}
+		if !bytes.Equal(res, content) {
+			if oid, err = rp.Repo.CreateBlobFromBuffer(res); err != nil {
+				return nil, err
+			}
+		}
+	}
+	rp.blobs[*id] = oid
+	return oid, nil
+}
+
+// Replace content of files in the tree.
+func (rp *ReplaceContentProcessor) ProcessTree(_ *git.Commit, tree *git.Tree) (*git.Tree, error) {
+	if rp.blobs == nil {
+		rp.blobs = map[git.Oid]*git.Oid{}
+	}
+	return processTree(rp.Repo, tree, func(_ string, entry *git.TreeEntry) (*git.Oid, error) {
+		return rp.replace(entry.Id)
+	}, &rp.cache)
+}
+
+// Processor updating submodule entries (gitlinks) of trees, so they point to
+// rewritten commits of submodules rewritten along with the superproject.
+type GitlinkProcessor struct {
+	Repo *git.Repository
+	// Mapping from original commits of submodules to rewritten ones.
+	Rewritten map[git.Oid]git.Oid
+	cache     treeCache
+}
+
+// Preprocess is no-op for GitlinkProcessor
+func (gp *GitlinkProcessor) Preprocess(_ Commits) error { return nil }
+
+// Process is no-op for GitlinkProcessor, only trees are changed.
+func (gp *GitlinkProcessor) Process(commit *git.Commit) (a, c *git.Signature, m string, e error) {
+	return commit.Author(), commit.Committer(), commit.RawMessage(), nil
+}
+
+// Point gitlinks of the tree to rewritten commits, blobs are left untouched.
+func (gp *GitlinkProcessor) ProcessTree(_ *git.Commit, tree *git.Tree) (*git.Tree, error) {
+	blobs := func(_ string, entry *git.TreeEntry) (*git.Oid, error) { return entry.Id, nil }
+	return processLinks(gp.Repo, tree, blobs, func(_ string, entry *git.TreeEntry) (*git.Oid, error) {
+		if id, ok := gp.Rewritten[*entry.Id]; ok {
+			return &id, nil
+		}
+		return entry.Id, nil
+	}, &gp.cache)
+}
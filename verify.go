@@ -0,0 +1,268 @@
+package historiography
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	git "gopkg.in/libgit2/git2go.v26"
+)
+
+// Difference found between an original commit and its rewritten counterpart.
+type Difference struct {
+	Old, New git.Oid
+	Reason   string
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s -> %s: %s", d.Old.String()[:10], d.New.String()[:10], d.Reason)
+}
+
+// Walk the original history from old and the rewritten one from new in
+// parallel, parents by parents, until they meet. Returns differences of
+// parent structure and, if trees is true, of content. Commits missing on one
+// side show up as differences of structure.
+func Verify(repo *git.Repository, old, new *git.Oid, trees bool) (diffs []Difference, err error) {
+	rewritten := map[git.Oid]git.Oid{} // original to rewritten commits
+	original := map[git.Oid]git.Oid{}  // and the other way around
+	stack := [][2]git.Oid{{*old, *new}}
+
+	for len(stack) > 0 {
+		o, n := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+		if o == n {
+			continue // histories met
+		}
+
+		// a commit rewritten twice, or two commits rewritten as one
+		if seen, ok := rewritten[o]; ok {
+			if seen != n {
+				diffs = append(diffs, Difference{o, n, "also rewritten as " + seen.String()[:10]})
+			}
+			continue
+		}
+		if seen, ok := original[n]; ok {
+			diffs = append(diffs, Difference{o, n, "also rewrites " + seen.String()[:10]})
+			continue
+		}
+		rewritten[o], original[n] = n, o
+
+		var before, after *git.Commit
+		if before, err = repo.LookupCommit(&o); err != nil {
+			return
+		}
+		if after, err = repo.LookupCommit(&n); err != nil {
+			before.Free()
+			return
+		}
+
+		if trees && !before.TreeId().Equal(after.TreeId()) {
+			diffs = append(diffs, Difference{o, n, "trees differ"})
+		}
+		if before.ParentCount() != after.ParentCount() {
+			diffs = append(diffs, Difference{o, n,
+				fmt.Sprintf("%d parents instead of %d", after.ParentCount(), before.ParentCount())})
+		} else {
+			for i := uint(0); i < before.ParentCount(); i++ {
+				stack = append(stack, [2]git.Oid{*before.ParentId(i), *after.ParentId(i)})
+			}
+		}
+		before.Free()
+		after.Free()
+	}
+
+	glog.V(2).Infof("%d commits verified, %d differences", len(rewritten), len(diffs))
+	return
+}
+
+// Verify histories ending at two revisions, see Verify.
+func VerifyRevisions(repo *git.Repository, old, new string, trees bool) ([]Difference, error) {
+	o, err := revision(repo, old)
+	if err != nil {
+		return nil, err
+	}
+	n, err := revision(repo, new)
+	if err != nil {
+		return nil, err
+	}
+	return Verify(repo, o, n, trees)
+}
+
+// Verify the rewritten history against the original one before overriding.
+// Trees are compared unless the processer changes them. When commits were
+// dropped, squashed or moved, see verifyMapped, commits are verified one by one
+// against the commits they are rewritten as.
+func (h *Historiography) Verify() ([]Difference, error) {
+	trees := !ChangesTrees(h.processer)
+	if len(h.moved) == 0 && len(h.skipped) == 0 && len(h.emptied) == 0 && !h.squashed() {
+		return Verify(h.repo, h.head.Target(), h.tmp.Target(), trees)
+	}
+	return h.verifyMapped(trees)
+}
+
+// Indicates if several commits are rewritten as the same one.
+func (h *Historiography) squashed() bool {
+	seen := map[git.Oid]bool{}
+	for _, commit := range Flatten(h.Commits) {
+		oid := *commit.Id()
+		if h.skipped[oid] || h.emptied[oid] {
+			continue
+		}
+		n := h.resolve(oid)
+		if seen[n] {
+			return true
+		}
+		seen[n] = true
+	}
+	return false
+}
+
+// Verify each commit which is not dropped against the commit it is rewritten
+// as: its parents must be its rewritten parents, dropped parents being
+// replaced by theirs, unless the commit has been moved. Commits squashed
+// together are verified as one, with the parents of the first one and the
+// tree of the last one. Trees are only compared until a commit is dropped or
+// moved, following commits lose or gain its changes, and for the last commit
+// unless commits were dropped. Every commit kept must be reachable from the
+// rewritten branch, which must not hold other commits.
+func (h *Historiography) verifyMapped(trees bool) (diffs []Difference, err error) {
+	commits := Flatten(h.Commits) // parents first
+	groups := map[git.Oid][]*git.Commit{}
+	order := []git.Oid{}
+	changed := map[git.Oid]bool{} // changes of dropped or moved commits lost
+	for _, commit := range commits {
+		oid := *commit.Id()
+		changed[oid] = h.skipped[oid] || h.moved[oid]
+		for i := uint(0); i < commit.ParentCount(); i++ {
+			changed[oid] = changed[oid] || changed[*commit.ParentId(i)]
+		}
+		if h.skipped[oid] || h.emptied[oid] {
+			continue
+		}
+		n := h.resolve(oid)
+		if _, ok := groups[n]; !ok {
+			order = append(order, n)
+		}
+		groups[n] = append(groups[n], commit)
+	}
+
+	for _, n := range order {
+		group := groups[n]
+		first, last := group[0], group[len(group)-1]
+		var after *git.Commit
+		if after, err = h.repo.LookupCommit(&n); err != nil {
+			return
+		}
+		if trees && !changed[*last.Id()] && !last.TreeId().Equal(after.TreeId()) {
+			diffs = append(diffs, Difference{*last.Id(), n, "trees differ"})
+		}
+		if !h.moved[*first.Id()] {
+			if reason := h.verifyParents(first, after); reason != "" {
+				diffs = append(diffs, Difference{*first.Id(), n, reason})
+			}
+		}
+		after.Free()
+	}
+
+	// every commit kept is part of the rewritten branch, and only them
+	var reachable []Difference
+	if reachable, err = h.verifyReachable(order, groups); err != nil {
+		return
+	}
+	diffs = append(diffs, reachable...)
+
+	// the content of the branch is kept unless changes were dropped
+	if trees && len(h.skipped) == 0 {
+		var before, after *git.Commit
+		if before, err = h.repo.LookupCommit(h.head.Target()); err != nil {
+			return
+		}
+		defer before.Free()
+		if after, err = h.repo.LookupCommit(h.tmp.Target()); err != nil {
+			return
+		}
+		defer after.Free()
+		if !before.TreeId().Equal(after.TreeId()) {
+			diffs = append(diffs, Difference{*before.Id(), *after.Id(), "trees differ"})
+		}
+	}
+
+	glog.V(2).Infof("%d commits verified, %d differences", len(order), len(diffs))
+	return
+}
+
+// Walk the rewritten branch down to the base of the rewrite and check that
+// commits rewritten are reachable from its tip, and that it holds as many
+// commits as kept by the rewrite. Commits are given as rewritten ids, in
+// order, and the original commits they rewrite.
+func (h *Historiography) verifyReachable(order []git.Oid, groups map[git.Oid][]*git.Commit) (
+	diffs []Difference, err error) {
+	var rev *git.RevWalk
+	if rev, err = h.repo.Walk(); err != nil {
+		return
+	}
+	defer rev.Free()
+
+	if err = rev.Push(h.tmp.Target()); err != nil {
+		return
+	}
+	rewritten := map[git.Oid]bool{}
+	for _, commit := range Flatten(h.Commits) {
+		rewritten[*commit.Id()] = true
+	}
+	for _, commit := range Flatten(h.Commits) {
+		for i := uint(0); i < commit.ParentCount(); i++ {
+			if parent := commit.ParentId(i); !rewritten[*parent] {
+				if err = rev.Hide(parent); err != nil {
+					return
+				}
+			}
+		}
+	}
+	reached := setIterator{}
+	if err = rev.Iterate(reached.RevWalkIterator); err != nil {
+		return
+	}
+
+	for _, n := range order {
+		if !reached[n] {
+			diffs = append(diffs, Difference{*groups[n][0].Id(), n, "not reachable from the rewritten branch"})
+		}
+	}
+	if len(reached) != len(order) {
+		diffs = append(diffs, Difference{*h.head.Target(), *h.tmp.Target(),
+			fmt.Sprintf("%d commits instead of %d", len(reached), len(order))})
+	}
+	return
+}
+
+// Compare parents of a rewritten commit with the rewritten parents of the
+// original one, see mappedParents. Returns the difference found, an empty
+// string if none.
+func (h *Historiography) verifyParents(before, after *git.Commit) string {
+	expected, actual := h.mappedParents(before), parentIds(after)
+	if len(actual) != len(expected) {
+		return fmt.Sprintf("%d parents instead of %d", len(actual), len(expected))
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			return fmt.Sprintf("parent %s instead of %s", actual[i].String()[:10], expected[i].String()[:10])
+		}
+	}
+	return ""
+}
+
+// Rewritten parents of a commit kept at its place, see Historiography.parents:
+// dropped parents are replaced by theirs and parents are only listed once.
+func (h *Historiography) mappedParents(commit *git.Commit) (parents []git.Oid) {
+	seen := map[git.Oid]bool{}
+	for _, id := range parentIds(commit) {
+		oid := h.resolve(id)
+		if _, ok := h.Rewritten[oid]; !ok && h.skipped[oid] {
+			continue // dropped root commit
+		}
+		if !seen[oid] {
+			seen[oid] = true
+			parents = append(parents, oid)
+		}
+	}
+	return
+}
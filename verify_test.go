@@ -0,0 +1,133 @@
+package historiography
+
+import (
+	git "gopkg.in/libgit2/git2go.v26"
+	"strings"
+	"testing"
+)
+
+// Copy a commit with commit-tree, changing its message and optionally its tree
+// and parents. Returns the id of the copy.
+func (tr *testRepo) copyCommit(id, tree string, parents ...string) string {
+	if tree == "" {
+		tree = tr.git("rev-parse", id+"^{tree}")
+	}
+	args := []string{"commit-tree", tree, "-m", "copy of " + id}
+	for _, parent := range parents {
+		args = append(args, "-p", parent)
+	}
+	return tr.git(args...)
+}
+
+// Reasons of differences, in order.
+func reasons(diffs []Difference) (res []string) {
+	for _, diff := range diffs {
+		res = append(res, diff.Reason)
+	}
+	return
+}
+
+func TestVerifyRevisions(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.Free()
+	base := tr.commit(testDate, "base", "a", "1")
+	one := tr.commit(testDate.Add(1), "one", "b", "1")
+	two := tr.commit(testDate.Add(2), "two", "b", "2")
+	three := tr.commit(testDate.Add(3), "three", "c", "1")
+
+	// copies of the history, one of them being altered
+	copies := func(tree2 string, extra ...string) string {
+		one2 := tr.copyCommit(one, "", base)
+		two2 := tr.copyCommit(two, tree2, one2)
+		return tr.copyCommit(three, "", append([]string{two2}, extra...)...)
+	}
+	oneTree := tr.git("rev-parse", one+"^{tree}")
+
+	tests := []struct {
+		name, old, new string
+		trees          bool
+		expected       []string
+	}{
+		{"same", three, three, true, nil},
+		{"messages", three, copies(""), true, nil},
+		{"content", three, copies(oneTree), true, []string{"trees differ"}},
+		{"content ignored", three, copies(oneTree), false, nil},
+		{"parents", three, copies("", base), true, []string{"2 parents instead of 1"}},
+		{"missing commit", three, tr.copyCommit(three, "", tr.copyCommit(one, "", base)), true,
+			[]string{"trees differ", "trees differ", "0 parents instead of 1"}},
+	}
+
+	for _, test := range tests {
+		diffs, err := VerifyRevisions(tr.repo, test.old, test.new, test.trees)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+			continue
+		}
+		expectStrings(t, test.name, test.expected, reasons(diffs))
+	}
+}
+
+func TestVerifyMapped(t *testing.T) {
+	drop, err := ParseExpression(nil, `message =~ "^two"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// alter the mapping of the rewrite, ids are the ones of original commits
+		tamper   func(tr *testRepo, h *Historiography, ids map[string]string)
+		expected []string
+	}{
+		{"drop", func(*testRepo, *Historiography, map[string]string) {}, nil},
+		{"wrong parent", func(tr *testRepo, h *Historiography, ids map[string]string) {
+			oid, _ := git.NewOid(tr.copyCommit(ids["three"], "", ids["base"]))
+			three, _ := git.NewOid(ids["three"])
+			h.Rewritten[*three] = *oid
+		}, []string{"parent ", "parent ", "not reachable"}},
+		{"wrong tree", func(tr *testRepo, h *Historiography, ids map[string]string) {
+			one, _ := git.NewOid(ids["one"])
+			rewritten := h.Rewritten[*one]
+			oid, _ := git.NewOid(tr.copyCommit(rewritten.String(), tr.git("rev-parse", ids["base"]+"^{tree}"),
+				ids["base"]))
+			h.Rewritten[*one] = *oid
+		}, []string{"trees differ", "parent ", "not reachable"}},
+		// the last commit is lost, e.g: replayed aside
+		{"lost commit", func(tr *testRepo, h *Historiography, ids map[string]string) {
+			three, _ := git.NewOid(ids["three"])
+			rewritten := h.Rewritten[*three]
+			if err := h.setTmp(&rewritten); err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"not reachable", "2 commits instead of 3"}},
+	}
+
+	for _, test := range tests {
+		tr := newTestRepo(t)
+		ids := planHistory(tr)
+
+		h, err := NewHistoriography(tr.repo, &DropProcessor{drop}, &Options{Commits: -1, Range: ids["base"]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = h.Process(Flatten(h.Commits)); err != nil {
+			t.Fatal(err)
+		}
+		test.tamper(tr, h, ids)
+		diffs, err := h.Verify()
+		h.Free()
+		tr.Free()
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+			continue
+		}
+		res := reasons(diffs)
+		for i := range res {
+			if i < len(test.expected) && strings.HasPrefix(res[i], test.expected[i]) {
+				res[i] = test.expected[i]
+			}
+		}
+		expectStrings(t, test.name, test.expected, res)
+	}
+}
@@ -0,0 +1,122 @@
+package historiography
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	git "gopkg.in/libgit2/git2go.v26"
+)
+
+// Count uncommitted changes to tracked files, in the index or the working
+// directory. Untracked files are left alone by the rewrite and not counted.
+func dirty(repo *git.Repository) (int, error) {
+	status, err := repo.StatusList(&git.StatusOptions{
+		Show:  git.StatusShowIndexAndWorkdir,
+		Flags: git.StatusOptExcludeSubmodules,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer status.Free()
+	return status.EntryCount()
+}
+
+// Stash uncommitted changes before switching to the temporary branch, see
+// Options.AutoStash. Refuses to go further on a dirty working directory
+// otherwise, as the checkout would clobber changes.
+func (h *Historiography) stash() (err error) {
+	changes, err := dirty(h.repo)
+	if err != nil || changes == 0 {
+		return
+	}
+	if !h.options.AutoStash {
+		return fmt.Errorf("working directory has %d uncommitted changes, commit or stash them first", changes)
+	}
+
+	sig, err := h.repo.DefaultSignature()
+	if err != nil {
+		return
+	}
+	if h.stashed, err = h.repo.Stashes.Save(sig,
+		fmt.Sprintf("historiography: autostash (run %s)", h.RunID), git.StashDefault); err != nil {
+		return
+	}
+	glog.V(1).Infof("%d uncommitted changes stashed as %s", changes, h.stashed.String()[:10])
+	return
+}
+
+// Error used to stop the iteration on stashes.
+var errFound = errors.New("found")
+
+// Index of a stash in the list of stashes, -1 if there is no such stash.
+func stashIndex(repo *git.Repository, id *git.Oid) (int, error) {
+	index := -1
+	err := repo.Stashes.Foreach(func(i int, message string, stash *git.Oid) error {
+		if stash.Equal(id) {
+			index = i
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return -1, err
+	}
+	return index, nil
+}
+
+// Reapply changes of a stash on the checked out branch and drop it, false is
+// returned if there is no such stash. The stash is kept if its changes do not
+// apply cleanly.
+func popStash(repo *git.Repository, id *git.Oid) (bool, error) {
+	index, err := stashIndex(repo, id)
+	if err != nil || index < 0 {
+		return false, err
+	}
+	opts, err := git.DefaultStashApplyOptions()
+	if err != nil {
+		return true, err
+	}
+	if err = repo.Stashes.Pop(index, opts); err != nil {
+		return true, fmt.Errorf("applying autostash failed (%s), your changes are kept in stash@{%d}", err, index)
+	}
+	return true, nil
+}
+
+// Reapply changes stashed by stash on the checked out branch, the stash is kept
+// if they do not apply cleanly.
+func (h *Historiography) unstash() error {
+	if h.stashed == nil {
+		return nil
+	}
+	found, err := popStash(h.repo, h.stashed)
+	if err == nil && !found {
+		err = fmt.Errorf("autostash %s not found", h.stashed.String()[:10])
+	}
+	if err != nil {
+		return err
+	}
+	h.stashed = nil
+	return nil
+}
+
+// Take over changes stashed by an interrupted run which did not reapply them,
+// e.g: killed, so that they are reapplied once the rewrite ends.
+func (h *Historiography) adoptStash(journal *Journal) error {
+	if journal.Stash == "" {
+		return nil
+	}
+	id, err := git.NewOid(journal.Stash)
+	if err != nil {
+		return fmt.Errorf("invalid journal: %s", err)
+	}
+	index, err := stashIndex(h.repo, id)
+	if err != nil || index < 0 {
+		return err
+	}
+	if h.stashed != nil {
+		glog.Warningf("changes stashed by run %s are kept in stash@{%d}", journal.RunID, index)
+		return nil
+	}
+	h.stashed = id
+	return nil
+}